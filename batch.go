@@ -0,0 +1,63 @@
+/*
+Copyright Medium Corp. 2020 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package mediumpk
+
+import (
+	"context"
+)
+
+// BatchResult is the result of each envelop of SignBatch and VerifyBatch.
+// Result, R, S and Err have the same meaning as return values of Request.
+type BatchResult struct {
+	Result int
+	R      []byte
+	S      []byte
+	Err    error
+}
+
+// SignBatch requests every sign envelop of envs and returns results in the same order.
+// Envelops are pipelined into every MBPU from the calling goroutine without waiting for each response.
+func (m *Manager) SignBatch(ctx context.Context, envs []SignRequestEnvelop) []BatchResult {
+	reqEnvs := make([]RequestEnvelop, len(envs))
+	for i := range envs {
+		reqEnvs[i] = envs[i]
+	}
+	return m.requestBatch(ctx, reqEnvs)
+}
+
+// VerifyBatch requests every verify envelop of envs and returns results in the same order.
+// Envelops are pipelined into every MBPU from the calling goroutine without waiting for each response.
+func (m *Manager) VerifyBatch(ctx context.Context, envs []VerifyRequestEnvelop) []BatchResult {
+	reqEnvs := make([]RequestEnvelop, len(envs))
+	for i := range envs {
+		reqEnvs[i] = envs[i]
+	}
+	return m.requestBatch(ctx, reqEnvs)
+}
+
+func (m *Manager) requestBatch(ctx context.Context, envs []RequestEnvelop) []BatchResult {
+	results := make([]BatchResult, len(envs))
+	reqs := make([]*requestWrapper, len(envs))
+	for i, env := range envs {
+		req, err := m.submit(ctx, env)
+		if err != nil {
+			results[i] = BatchResult{-1, []byte(nil), []byte(nil), err}
+			continue
+		}
+		reqs[i] = req
+	}
+
+	for i, req := range reqs {
+		if req == nil {
+			continue
+		}
+		result, r, s, err := req.wait()
+		results[i] = BatchResult{result, r, s, err}
+	}
+
+	return results
+}
@@ -0,0 +1,67 @@
+package mediumpk
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignBatch(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	count := maxPending*2 + 1
+	hashes := make([][]byte, count)
+	envs := make([]SignRequestEnvelop, count)
+	for i := range envs {
+		h := sha256.Sum256([]byte(randString()))
+		hashes[i] = h[:]
+		k, err := CreateRandomK(priv.D.Bytes(), h[:])
+		assert.NoError(t, err)
+		envs[i], err = NewSignRequestEnvelop(priv.D.Bytes(), k, h[:])
+		assert.NoError(t, err)
+	}
+	envs[1].K = make([]byte, 32)
+
+	results := SignBatch(context.Background(), envs)
+	assert.Equal(t, count, len(results))
+	for i, res := range results {
+		if i == 1 {
+			assert.True(t, errors.Is(res.Err, ErrInvalidEnvelop))
+			continue
+		}
+		assert.NoError(t, res.Err)
+		assert.Equal(t, 0, res.Result)
+		assert.True(t, VerifyCPU(&priv.PublicKey, hashes[i], new(big.Int).SetBytes(res.R), new(big.Int).SetBytes(res.S)))
+	}
+}
+
+func TestVerifyBatch(t *testing.T) {
+	count := maxPending*2 + 1
+	envs := make([]VerifyRequestEnvelop, count)
+	for i := range envs {
+		workload := data[i]
+		var err error
+		envs[i], err = NewVerifyRequestEnvelop(workload.qx, workload.qy, workload.r, workload.s, workload.h)
+		assert.NoError(t, err)
+	}
+	envs[3].H = envs[4].H
+
+	results := VerifyBatch(context.Background(), envs)
+	assert.Equal(t, count, len(results))
+	for i, res := range results {
+		if i == 3 {
+			assert.True(t, errors.Is(res.Err, ErrVerifyFailed))
+			continue
+		}
+		assert.NoError(t, res.Err)
+		assert.Equal(t, 0, res.Result)
+	}
+}
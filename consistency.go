@@ -0,0 +1,140 @@
+/*
+Copyright Medium Corp. 2020 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package mediumpk
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"sync/atomic"
+)
+
+// ConsistencyCheck is how signatures generated by MBPU are verified before they are returned
+type ConsistencyCheck int
+
+const (
+	// ConsistencyCheckOff returns signatures as they are
+	ConsistencyCheckOff ConsistencyCheck = iota
+	// ConsistencyCheckCPU verifies signatures on CPU with VerifyCPU
+	ConsistencyCheckCPU
+	// ConsistencyCheckDevice verifies signatures on another MBPU, on CPU if there is no other MBPU up
+	ConsistencyCheckDevice
+)
+
+func (c ConsistencyCheck) String() string {
+	switch c {
+	case ConsistencyCheckOff:
+		return "off"
+	case ConsistencyCheckCPU:
+		return "cpu"
+	case ConsistencyCheckDevice:
+		return "device"
+	}
+	return fmt.Sprintf("ConsistencyCheck(%d)", int(c))
+}
+
+// errNoVerifier is returned when there is no other MBPU up to verify signature
+var errNoVerifier = errors.New("no mbpu to verify signature")
+
+// needsConsistencyCheck tells whether the response of req is to be verified before it is returned
+func (mgr *Manager) needsConsistencyCheck(req *requestWrapper, resEnv ResponseEnvelop) bool {
+	if mgr.consistencyCheck == ConsistencyCheckOff || resEnv.Result() != resultOK {
+		return false
+	}
+	if _, ok := req.env.(SignRequestEnvelop); !ok {
+		return false
+	}
+	return mgr.consistencySampleRate >= 1 || rand.Float64() < mgr.consistencySampleRate
+}
+
+// checkConsistency verifies signature in resEnv of sign request req the way check says and tells
+// whether it is valid. MBPU which rejects valid signature while verifying it is declared down.
+func (m *Mediumpk) checkConsistency(req *requestWrapper, resEnv ResponseEnvelop, check ConsistencyCheck) bool {
+	env := req.env.(SignRequestEnvelop)
+	stats := &m.stats[opSign]
+	atomic.AddUint64(&stats.checks, 1)
+
+	c := env.Curve.Elliptic()
+	pub := &ecdsa.PublicKey{Curve: c}
+	pub.X, pub.Y = c.ScalarBaseMult(env.D)
+	// h of envelope queued by Manager is canonical, that is, digest ecdsa takes
+	rBytes, sBytes := resEnv.Signature()
+	r, s := new(big.Int).SetBytes(rBytes), new(big.Int).SetBytes(sBytes)
+
+	valid := false
+	var verifier *Mediumpk
+	var err error = errNoVerifier
+	if check == ConsistencyCheckDevice {
+		verifier, valid, err = m.manager.verifyOnOtherDevice(req.ctx, m.index, env.Curve, pub, env.H, rBytes, sBytes)
+	}
+	if err != nil {
+		atomic.AddUint64(&stats.cpuChecks, 1)
+		return VerifyCPU(pub, env.H, r, s)
+	}
+	if !valid && VerifyCPU(pub, env.H, r, s) {
+		// verifier is wrong, not the signer
+		atomic.AddUint64(&verifier.stats[opVerify].mismatches, 1)
+		verifier.logger.Error("MBPU rejected valid signature")
+		verifier.markDown(ErrInconsistentSignature)
+		return true
+	}
+	return valid
+}
+
+// answerChecked answers req with resEnv whose signature is checked valid. Signature which does not
+// verify is not returned, and MBPU generated it is declared down.
+func (m *Mediumpk) answerChecked(req *requestWrapper, resEnv ResponseEnvelop, valid bool) {
+	if valid {
+		req.notify(resEnv)
+		return
+	}
+
+	atomic.AddUint64(&m.stats[opSign].mismatches, 1)
+	m.logger.Error("MBPU generated signature which does not verify")
+	m.markDown(ErrInconsistentSignature)
+	m.manager.serveEmergency(req, &DeviceError{m.index, ErrInconsistentSignature})
+}
+
+// verifyOnOtherDevice verifies signature r, s of curve with MBPU other than the one of index exclude.
+// It returns the MBPU used, and errNoVerifier if there is none up.
+func (mgr *Manager) verifyOnOtherDevice(ctx context.Context, exclude int, curve Curve, pub *ecdsa.PublicKey, hash, r, s []byte) (*Mediumpk, bool, error) {
+	env, err := NewCurveVerifyRequestEnvelop(curve, pub.X.Bytes(), pub.Y.Bytes(), r, s, hash)
+	if err != nil {
+		return nil, false, err
+	}
+
+	n := len(mgr.mpks)
+	start := int(atomic.AddUint32(&mgr.verifierIndex, 1))
+	for i := 0; i < n; i++ {
+		mpk := mgr.mpks[(start+i)%n]
+		if mpk.index == exclude || !mpk.curves.has(curve) || atomic.LoadInt32(&mpk.emergency) == 1 {
+			continue
+		}
+
+		req := newRequestWrapper(ctx, env)
+		select {
+		case mpk.chDirect <- req:
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		case <-mgr.closing:
+			return nil, false, ErrManagerClosed
+		}
+
+		_, _, _, err = req.wait()
+		if errors.Is(err, ErrVerifyFailed) {
+			return mpk, false, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		return mpk, true, nil
+	}
+	return nil, false, errNoVerifier
+}
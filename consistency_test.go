@@ -0,0 +1,140 @@
+package mediumpk
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSignRequest(t *testing.T) SignRequestEnvelop {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	h := sha256.Sum256([]byte(randString()))
+	k, err := CreateRandomK(priv.D.Bytes(), h[:])
+	assert.NoError(t, err)
+	env, err := NewSignRequestEnvelop(priv.D.Bytes(), k, h[:])
+	assert.NoError(t, err)
+	return env
+}
+
+func TestConsistencyCheckCPU(t *testing.T) {
+	dev := &wrongDevice{SimulatorDevice: NewSimulatorDevice(), correct: 1}
+	m := newTestManager(t, Options{Devices: []Device{dev}, ConsistencyCheck: ConsistencyCheckCPU, HealthCheckInterval: -1})
+
+	for i := 0; i < 10; i++ {
+		result, _, _, err := m.Request(newTestSignRequest(t))
+		assert.NoError(t, err)
+		assert.Equal(t, 0, result)
+	}
+	assert.Equal(t, uint64(10), m.Stats()[0].Sign.Checks)
+	assert.Equal(t, uint64(10), m.Stats()[0].Sign.CPUChecks)
+	assert.Equal(t, uint64(0), m.Stats()[0].Sign.Mismatches)
+
+	atomic.StoreInt32(&dev.correct, 0)
+	_, _, _, err := m.Request(newTestSignRequest(t))
+	assert.True(t, errors.Is(err, ErrInconsistentSignature))
+	var devErr *DeviceError
+	assert.True(t, errors.As(err, &devErr))
+	assert.Equal(t, 0, devErr.Index)
+	assert.Equal(t, uint64(1), m.Stats()[0].Sign.Mismatches)
+
+	// device is declared down
+	waitEmergency(t, m.mpks[0], 1)
+}
+
+func TestConsistencyCheckDevice(t *testing.T) {
+	dev := &wrongDevice{SimulatorDevice: NewSimulatorDevice(), correct: 1}
+	m := newTestManager(t, Options{Devices: []Device{dev, NewSimulatorDevice()}, ConsistencyCheck: ConsistencyCheckDevice,
+		ConsistencyWorkers: 2, HealthCheckInterval: -1})
+
+	for i := 0; i < 10; i++ {
+		_, _, _, err := m.Request(newTestSignRequest(t))
+		assert.NoError(t, err)
+	}
+	stats := m.Stats()
+	assert.Equal(t, uint64(10), stats[0].Sign.Checks+stats[1].Sign.Checks)
+	assert.Equal(t, stats[0].Sign.Checks, stats[1].Verify.Requests)
+	assert.Equal(t, stats[1].Sign.Checks, stats[0].Verify.Requests)
+	assert.Equal(t, uint64(0), stats[0].Sign.CPUChecks+stats[1].Sign.CPUChecks)
+
+	// sign on the wrong device, which is caught by the other one
+	atomic.StoreInt32(&dev.correct, 0)
+	req := newRequestWrapper(context.Background(), newTestSignRequest(t))
+	m.mpks[0].chDirect <- req
+	_, _, _, err := req.wait()
+	assert.True(t, errors.Is(err, ErrInconsistentSignature))
+	assert.Equal(t, uint64(1), m.Stats()[0].Sign.Mismatches)
+	assert.Equal(t, uint64(0), m.Stats()[1].Verify.Mismatches)
+
+	waitEmergency(t, m.mpks[0], 1)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&m.mpks[1].emergency))
+}
+
+func TestConsistencyWorkers(t *testing.T) {
+	dev := &wrongDevice{SimulatorDevice: NewSimulatorDevice(), correct: 1}
+	m := newTestManager(t, Options{Devices: []Device{dev, NewSimulatorDevice()}, ConsistencyCheck: ConsistencyCheckDevice,
+		ConsistencyWorkers: -1, HealthCheckInterval: -1})
+	assert.Equal(t, 0, cap(m.consistencySlots))
+
+	// polling-goroutines verify on CPU
+	futures := make([]*Future, 20)
+	for i := range futures {
+		futures[i] = m.Submit(context.Background(), newTestSignRequest(t))
+	}
+	for _, f := range futures {
+		_, _, _, err := f.Wait()
+		assert.NoError(t, err)
+	}
+	stats := m.Stats()
+	assert.Equal(t, uint64(20), stats[0].Sign.Checks+stats[1].Sign.Checks)
+	assert.Equal(t, uint64(0), stats[0].Verify.Requests+stats[1].Verify.Requests)
+	assert.Equal(t, uint64(20), stats[0].Sign.CPUChecks+stats[1].Sign.CPUChecks)
+
+	atomic.StoreInt32(&dev.correct, 0)
+	req := newRequestWrapper(context.Background(), newTestSignRequest(t))
+	m.mpks[0].chDirect <- req
+	_, _, _, err := req.wait()
+	assert.True(t, errors.Is(err, ErrInconsistentSignature))
+	waitEmergency(t, m.mpks[0], 1)
+
+	m = newTestManager(t, Options{Devices: []Device{NewSimulatorDevice()}, ConsistencyCheck: ConsistencyCheckCPU})
+	assert.Equal(t, runtime.NumCPU(), cap(m.consistencySlots))
+}
+
+func TestConsistencyCheck_Shutdown(t *testing.T) {
+	m := newTestManager(t, Options{Devices: []Device{NewSimulatorDevice()}, ConsistencyCheck: ConsistencyCheckCPU})
+
+	futures := make([]*Future, 50)
+	for i := range futures {
+		futures[i] = m.Submit(context.Background(), newTestSignRequest(t))
+	}
+	assert.NoError(t, m.Close())
+
+	// checks are over by the time shutdown is
+	assert.Equal(t, 0, len(m.consistencySlots))
+	for _, f := range futures {
+		select {
+		case <-f.Done():
+		default:
+			t.Fatal("request is not answered after shutdown")
+		}
+	}
+}
+
+// waitEmergency waits until emergency state of mpk becomes state
+func waitEmergency(t *testing.T, mpk *Mediumpk, state int32) {
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&mpk.emergency) != state && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, state, atomic.LoadInt32(&mpk.emergency))
+}
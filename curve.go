@@ -0,0 +1,137 @@
+/*
+Copyright Medium Corp. 2020 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package mediumpk
+
+import (
+	"crypto/elliptic"
+	"fmt"
+)
+
+// Curve is the elliptic curve of request. The zero value is P-256, which every MBPU computes.
+type Curve int
+
+const (
+	// CurveP256 is NIST P-256, also known as secp256r1
+	CurveP256 Curve = iota
+	// CurveP224 is NIST P-224
+	CurveP224
+	// CurveP384 is NIST P-384
+	CurveP384
+	// CurveP521 is NIST P-521
+	CurveP521
+	// CurveSecp256k1 is secp256k1 of SEC 2
+	CurveSecp256k1
+
+	numCurves = 5
+)
+
+// frameFieldSize is the size of fields of request frame, curves of larger fields are computed on CPU
+const frameFieldSize = 32
+
+func (c Curve) String() string {
+	switch c {
+	case CurveP256:
+		return "P-256"
+	case CurveP224:
+		return "P-224"
+	case CurveP384:
+		return "P-384"
+	case CurveP521:
+		return "P-521"
+	case CurveSecp256k1:
+		return "secp256k1"
+	}
+	return fmt.Sprintf("Curve(%d)", int(c))
+}
+
+// Elliptic returns implementation of c, nil if c is not a known curve
+func (c Curve) Elliptic() elliptic.Curve {
+	switch c {
+	case CurveP256:
+		return elliptic.P256()
+	case CurveP224:
+		return elliptic.P224()
+	case CurveP384:
+		return elliptic.P384()
+	case CurveP521:
+		return elliptic.P521()
+	case CurveSecp256k1:
+		return secp256k1
+	}
+	return nil
+}
+
+// size returns the size in bytes of scalars and coordinates of c
+func (c Curve) size() int {
+	return (c.Elliptic().Params().BitSize + 7) / 8
+}
+
+// checkCurve returns error if c is not a known curve
+func checkCurve(c Curve) error {
+	if c < CurveP256 || c >= numCurves {
+		return fmt.Errorf("%w: %v", ErrUnsupportedCurve, c)
+	}
+	return nil
+}
+
+// checkSignCurve returns error if c is not a known curve or private keys of c must not be used here.
+// secp256k1 is implemented in variable time, so it is supported for verification only.
+func checkSignCurve(c Curve) error {
+	if err := checkCurve(c); err != nil {
+		return err
+	}
+	if c == CurveSecp256k1 {
+		return fmt.Errorf("%w: %v is supported for verification only", ErrUnsupportedCurve, c)
+	}
+	return nil
+}
+
+// CurveOf returns Curve of elliptic curve implementation c
+func CurveOf(c elliptic.Curve) (Curve, error) {
+	for curve := CurveP256; curve < numCurves; curve++ {
+		if curve.Elliptic() == c {
+			return curve, nil
+		}
+	}
+	if c == nil {
+		return 0, ErrUnsupportedCurve
+	}
+	return 0, fmt.Errorf("%w: %s", ErrUnsupportedCurve, c.Params().Name)
+}
+
+// curveSet is a set of curves, bit i for Curve(i)
+type curveSet uint32
+
+// frameCurves are the curves fitting in request frame, which MBPU may be configured to compute
+var frameCurves = newCurveSet(CurveP256, CurveP224, CurveSecp256k1)
+
+func newCurveSet(curves ...Curve) curveSet {
+	var s curveSet
+	for _, c := range curves {
+		s |= 1 << uint(c)
+	}
+	return s
+}
+
+func (s curveSet) has(c Curve) bool {
+	return s&(1<<uint(c)) != 0
+}
+
+// deviceCurves returns curves MBPUs compute, P-256 and curves of Options.DeviceCurves
+func deviceCurves(curves []Curve) (curveSet, error) {
+	s := newCurveSet(CurveP256)
+	for _, c := range curves {
+		if err := checkCurve(c); err != nil {
+			return 0, err
+		}
+		if !frameCurves.has(c) {
+			return 0, fmt.Errorf("%w: %v does not fit in mbpu frame", ErrUnsupportedCurve, c)
+		}
+		s |= newCurveSet(c)
+	}
+	return s, nil
+}
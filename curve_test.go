@@ -0,0 +1,206 @@
+package mediumpk
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecp256k1(t *testing.T) {
+	c := CurveSecp256k1.Elliptic()
+	params := c.Params()
+	assert.True(t, c.IsOnCurve(params.Gx, params.Gy))
+
+	x, y := c.Double(params.Gx, params.Gy)
+	assert.Equal(t, "c6047f9441ed7d6d3045406e95c07cd85c778e4b8cef3ca7abac09b95c709ee5", fmt.Sprintf("%064x", x))
+	assert.Equal(t, "1ae168fea63dc339a3c58419466ceaeef7f632653266d0e1236431a950cfe52a", fmt.Sprintf("%064x", y))
+	x3, y3 := c.Add(x, y, params.Gx, params.Gy)
+	bx, by := c.ScalarBaseMult([]byte{3})
+	assert.Equal(t, x3, bx)
+	assert.Equal(t, y3, by)
+
+	// N*G is the point at infinity
+	x, y = c.ScalarBaseMult(params.N.Bytes())
+	assert.Equal(t, 0, x.Sign())
+	assert.Equal(t, 0, y.Sign())
+	assert.False(t, c.IsOnCurve(params.Gx, new(big.Int).Add(params.Gy, big.NewInt(1))))
+}
+
+func TestCurveOf(t *testing.T) {
+	for c := CurveP256; c < numCurves; c++ {
+		curve, err := CurveOf(c.Elliptic())
+		assert.NoError(t, err)
+		assert.Equal(t, c, curve)
+	}
+	_, err := CurveOf(elliptic.P256().Params())
+	assert.True(t, errors.Is(err, ErrUnsupportedCurve))
+	_, err = NewCurveSignRequestEnvelop(Curve(numCurves), []byte{1}, []byte{1}, []byte{1})
+	assert.True(t, errors.Is(err, ErrInvalidEnvelop))
+}
+
+func TestDeviceCurves(t *testing.T) {
+	curves, err := deviceCurves(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, newCurveSet(CurveP256), curves)
+
+	curves, err = deviceCurves([]Curve{CurveP224, CurveSecp256k1})
+	assert.NoError(t, err)
+	assert.Equal(t, frameCurves, curves)
+
+	// fields of P-384 do not fit in frame
+	_, err = deviceCurves([]Curve{CurveP384})
+	assert.True(t, errors.Is(err, ErrUnsupportedCurve))
+	_, err = NewManager(Options{Devices: []Device{NewSimulatorDevice()}, MaxPending: 1, DeviceCurves: []Curve{CurveP384}})
+	assert.True(t, errors.Is(err, ErrUnsupportedCurve))
+}
+
+func TestSerializeRequest_Curve(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	assert.NoError(t, err)
+	env, err := NewCurveSignRequestEnvelop(CurveP224, priv.D.Bytes(), priv.D.Bytes(), []byte{1})
+	assert.NoError(t, err)
+	assert.Equal(t, 28, len(env.D))
+
+	buffer, err := env.Bytes(serializer{}, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0xaa, 0xaa, 0xaa, 0xaa, 0, 0, 0, byte(CurveP224)}, buffer[0:8])
+	assert.Equal(t, env.D, buffer[20:48])
+	assert.Equal(t, make([]byte, 4), buffer[16:20])
+
+	// fields of P-384 do not fit in frame
+	priv, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	assert.NoError(t, err)
+	env, err = NewCurveSignRequestEnvelop(CurveP384, priv.D.Bytes(), priv.D.Bytes(), []byte{1})
+	assert.NoError(t, err)
+	_, err = env.Bytes(serializer{}, 1)
+	assert.True(t, errors.Is(err, ErrInvalidEnvelop))
+}
+
+func testRequestCurves(t *testing.T, m *Manager) {
+	for c := CurveP256; c < numCurves; c++ {
+		priv, err := ecdsa.GenerateKey(c.Elliptic(), rand.Reader)
+		assert.NoError(t, err)
+		digest := sha512.Sum512([]byte(c.String()))
+
+		var sig []byte
+		s, err := m.NewSigner(priv)
+		if c == CurveSecp256k1 {
+			// secp256k1 is supported for verification only
+			assert.True(t, errors.Is(err, ErrUnsupportedCurve))
+			r, s, err := ecdsa.Sign(rand.Reader, priv, digest[:])
+			assert.NoError(t, err)
+			sig, err = asn1.Marshal(ecdsaSignature{r, s})
+			assert.NoError(t, err)
+		} else {
+			assert.NoError(t, err)
+			sig, err = s.SignContext(context.Background(), digest[:])
+			assert.NoError(t, err, c.String())
+			var decoded ecdsaSignature
+			_, err = asn1.Unmarshal(sig, &decoded)
+			assert.NoError(t, err)
+			assert.True(t, ecdsa.Verify(&priv.PublicKey, digest[:], decoded.R, decoded.S), c.String())
+		}
+
+		ok, err := m.Verify(&priv.PublicKey, digest[:], sig)
+		assert.NoError(t, err)
+		assert.True(t, ok, c.String())
+		digest[0] ^= 0xff
+		ok, err = m.Verify(&priv.PublicKey, digest[:], sig)
+		assert.NoError(t, err)
+		assert.False(t, ok, c.String())
+	}
+	_, count := m.cpuFallbackState()
+	assert.Equal(t, uint64(0), count)
+}
+
+func TestRequest_Curve(t *testing.T) {
+	// curves other than P-256 are computed on CPU unless configured
+	m := newTestManager(t, Options{Devices: []Device{NewSimulatorDevice()}})
+	assert.Equal(t, newCurveSet(CurveP256), m.mpks[0].curves)
+	testRequestCurves(t, m)
+	stats := m.Stats()
+	assert.Equal(t, uint64(1), stats[0].Sign.Requests)
+	assert.Equal(t, uint64(2), stats[0].Verify.Requests)
+
+	// P-384 and P-521 are computed on CPU whatever is configured
+	m = newTestManager(t, Options{
+		Devices:      []Device{NewSimulatorDevice()},
+		DeviceCurves: []Curve{CurveP224, CurveSecp256k1},
+	})
+	testRequestCurves(t, m)
+	stats = m.Stats()
+	assert.Equal(t, uint64(2), stats[0].Sign.Requests)
+	assert.Equal(t, uint64(6), stats[0].Verify.Requests)
+}
+
+// curvelessDevice is a SimulatorDevice of bitstream not taking curve, computing every request as P-256
+type curvelessDevice struct {
+	*SimulatorDevice
+}
+
+func (d curvelessDevice) Request(buffer []byte) error {
+	for i := 4; i < 8; i++ {
+		buffer[i] = 0
+	}
+	return d.SimulatorDevice.Request(buffer)
+}
+
+func TestRequest_CurveSelfTest(t *testing.T) {
+	// curves MBPU gets wrong are computed on CPU
+	m := newTestManager(t, Options{
+		Devices:      []Device{curvelessDevice{NewSimulatorDevice()}},
+		DeviceCurves: []Curve{CurveP224, CurveSecp256k1},
+	})
+	assert.Equal(t, newCurveSet(CurveP256), m.mpks[0].curves)
+	assert.Equal(t, newCurveSet(CurveP256), m.curves)
+	testRequestCurves(t, m)
+	stats := m.Stats()
+	assert.Equal(t, uint64(1), stats[0].Sign.Requests)
+	assert.Equal(t, uint64(2), stats[0].Verify.Requests)
+}
+
+func TestRequest_P521Literal(t *testing.T) {
+	m := newTestManager(t, Options{Devices: []Device{NewSimulatorDevice()}})
+
+	priv, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	assert.NoError(t, err)
+	digest := make([]byte, 66)
+	_, err = rand.Read(digest)
+	assert.NoError(t, err)
+	k, err := CreateRandomKCurve(CurveP521, priv.D.Bytes(), digest)
+	assert.NoError(t, err)
+
+	// h of struct literal is taken as ecdsa takes digest, as NewCurveSignRequestEnvelop does
+	env := SignRequestEnvelop{D: priv.D.Bytes(), K: k, H: digest, Curve: CurveP521}
+	result, r, s, err := m.Request(env)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result)
+	assert.True(t, ecdsa.Verify(&priv.PublicKey, digest, new(big.Int).SetBytes(r), new(big.Int).SetBytes(s)))
+
+	verifyEnv := VerifyRequestEnvelop{Qx: priv.X.Bytes(), Qy: priv.Y.Bytes(), R: r, S: s, H: digest, Curve: CurveP521}
+	result, _, _, err = m.Request(verifyEnv)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result)
+
+	// canonical h is canonical
+	canonical, err := env.canonical()
+	assert.NoError(t, err)
+	again, err := canonical.canonical()
+	assert.NoError(t, err)
+	assert.Equal(t, canonical, again)
+
+	// h too long is refused rather than taken as zero
+	env.H = append(digest, 0)
+	res := computeCPU(env)
+	assert.Equal(t, -1, res.Result())
+	assert.True(t, errors.Is(res.err, ErrInvalidEnvelop))
+}
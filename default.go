@@ -0,0 +1,149 @@
+/*
+Copyright Medium Corp. 2020 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package mediumpk
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+)
+
+// defaultManager returns Manager initialized by InitMBPUManager, nil if it is not initialized
+func defaultManager() *Manager {
+	lock.RLock()
+	defer lock.RUnlock()
+	return fm
+}
+
+// InitMBPUManager opens MBPU device and runs goroutine each for request/response to/from MBPU.
+// It initializes the default Manager used by package-level functions.
+func InitMBPUManager(mbpuCount int, maxPending int, metricSocketPath string) error {
+	if mbpuCount < 1 {
+		return fmt.Errorf("mbpuCount must larger than or equal to 1")
+	}
+	return initDefaultManager(Options{
+		DeviceCount:      mbpuCount,
+		MaxPending:       maxPending,
+		MetricSocketPath: metricSocketPath,
+	})
+}
+
+// InitMBPUManagerWithDevices runs goroutine each for request/response to/from given devices.
+// It is useful to run manager with SimulatorDevice where MBPU is not installed.
+func InitMBPUManagerWithDevices(devices []Device, maxPending int, metricSocketPath string) error {
+	if len(devices) < 1 {
+		return fmt.Errorf("mbpuCount must larger than or equal to 1")
+	}
+	return initDefaultManager(Options{
+		Devices:          devices,
+		MaxPending:       maxPending,
+		MetricSocketPath: metricSocketPath,
+	})
+}
+
+func initDefaultManager(opts Options) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if fm != nil {
+		return fmt.Errorf("mbpu manager is already initialized")
+	}
+
+	m, err := NewManager(opts)
+	if err != nil {
+		return err
+	}
+	fm = m
+	return nil
+}
+
+// CloseMBPUManager closes MBPU Device and stops goroutines for request/response to/from MBPU
+func CloseMBPUManager() error {
+	m := takeDefaultManager()
+	if m == nil {
+		return ErrManagerClosed
+	}
+	return m.Close()
+}
+
+// ShutdownMBPUManager shuts the default Manager down gracefully, see Manager.Shutdown
+func ShutdownMBPUManager(ctx context.Context) error {
+	m := takeDefaultManager()
+	if m == nil {
+		return ErrManagerClosed
+	}
+	return m.Shutdown(ctx)
+}
+
+// takeDefaultManager unsets the default Manager and returns it, so that package-level functions
+// fail with ErrManagerClosed instead of waiting while it shuts down
+func takeDefaultManager() *Manager {
+	lock.Lock()
+	defer lock.Unlock()
+
+	m := fm
+	fm = nil
+	return m
+}
+
+// Request send RequestEnvelop to the default Manager and waits for response, see Manager.Request
+func Request(env RequestEnvelop) (int, []byte, []byte, error) {
+	return defaultManager().Request(env)
+}
+
+// RequestContext is like Request but gives up when ctx is done, see Manager.RequestContext
+func RequestContext(ctx context.Context, env RequestEnvelop) (int, []byte, []byte, error) {
+	return defaultManager().RequestContext(ctx, env)
+}
+
+// TryRequest is like Request but fails fast with ErrQueueFull when the queue of the default Manager
+// is full, see Manager.TryRequest
+func TryRequest(env RequestEnvelop) (int, []byte, []byte, error) {
+	return defaultManager().TryRequest(env)
+}
+
+// TryRequestContext is like RequestContext but fails fast with ErrQueueFull when the queue of
+// the default Manager is full, see Manager.TryRequestContext
+func TryRequestContext(ctx context.Context, env RequestEnvelop) (int, []byte, []byte, error) {
+	return defaultManager().TryRequestContext(ctx, env)
+}
+
+// Submit hands env over to the default Manager without waiting for response, see Manager.Submit
+func Submit(ctx context.Context, env RequestEnvelop) *Future {
+	return defaultManager().Submit(ctx, env)
+}
+
+// SignBatch requests sign envelops to the default Manager, see Manager.SignBatch
+func SignBatch(ctx context.Context, envs []SignRequestEnvelop) []BatchResult {
+	return defaultManager().SignBatch(ctx, envs)
+}
+
+// VerifyBatch requests verify envelops to the default Manager, see Manager.VerifyBatch
+func VerifyBatch(ctx context.Context, envs []VerifyRequestEnvelop) []BatchResult {
+	return defaultManager().VerifyBatch(ctx, envs)
+}
+
+// SetCPUFallback turns CPU fallback of the default Manager on or off, see Manager.SetCPUFallback
+func SetCPUFallback(enabled bool) error {
+	m := defaultManager()
+	if m == nil {
+		return ErrManagerClosed
+	}
+	m.SetCPUFallback(enabled)
+	return nil
+}
+
+// Verify verifies ASN.1 DER encoded signature with the default Manager, see Manager.Verify
+func Verify(pub *ecdsa.PublicKey, hash, sig []byte) (bool, error) {
+	return defaultManager().Verify(pub, hash, sig)
+}
+
+// VerifyRS verifies signature r, s with the default Manager, see Manager.VerifyRS
+func VerifyRS(pub *ecdsa.PublicKey, hash []byte, r, s *big.Int) (bool, error) {
+	return defaultManager().VerifyRS(pub, hash, r, s)
+}
@@ -0,0 +1,46 @@
+/*
+Copyright Medium Corp. 2020 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package mediumpk
+
+import (
+	"github.com/the-medium/mediumpk/internal"
+)
+
+// Device is the interface to interact with a MBPU.
+// Request and Poll exchange raw request/response frames, the others read or control device state.
+type Device interface {
+	// Request sends a sign/verify request frame into device
+	Request(buffer []byte) error
+	// Poll brings a response frame from device, it blocks until a response is ready
+	Poll() ([]byte, error)
+	// GetMetrics returns raw device metric information
+	GetMetrics() ([]byte, error)
+	// Reset resets device
+	Reset() error
+	// Version returns device version information
+	Version() (string, error)
+	// Close releases device
+	Close() error
+}
+
+var _ Device = (*internal.FPGADevice)(nil)
+
+// AvailabilityChecker is implemented by devices that can tell whether their channels are usable.
+// Health checker calls it before resetting a device which is down.
+type AvailabilityChecker interface {
+	CheckAvailable() error
+}
+
+var _ AvailabilityChecker = (*internal.FPGADevice)(nil)
+
+// PollCanceler is implemented by devices that can make Poll blocked on them return.
+// Watchdog calls it on a device declared stuck, Poll must fail until Reset is called.
+type PollCanceler interface {
+	CancelPoll() error
+}
+
+var _ PollCanceler = (*internal.FPGADevice)(nil)
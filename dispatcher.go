@@ -0,0 +1,154 @@
+/*
+Copyright Medium Corp. 2020 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package mediumpk
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// throttleInterval is the interval of reading temperature of MBPUs for throttling
+	throttleInterval = time.Second
+	// throttleHysteresis is how much MBPU must cool down below ThrottleTemperature to be used again
+	throttleHysteresis = 5.0
+	// defaultMaxRetries is the default of Options.MaxRetries
+	defaultMaxRetries = 2
+)
+
+// runDispatcher runs dispatcher-goroutine routing requests to push-goroutine of MBPUs
+func (mgr *Manager) runDispatcher() {
+	go func() {
+		defer mgr.wg.Done()
+
+		for {
+			req, ok := mgr.nextRequest()
+			if !ok { // terminate this loop by Shutdown
+				mgr.drainQueue()
+				return
+			}
+			mgr.dispatch(req)
+		}
+	}()
+}
+
+// runThrottle runs throttle-goroutine reading temperature of MBPUs every throttleInterval until shutdown.
+// It is apart from dispatcher-goroutine, which is kept busy as long as requests are queued.
+func (mgr *Manager) runThrottle() {
+	go func() {
+		defer mgr.wg.Done()
+
+		ticker := time.NewTicker(throttleInterval)
+		defer ticker.Stop()
+		mgr.updateThrottle()
+		for {
+			select {
+			case <-ticker.C:
+				mgr.updateThrottle()
+			case <-mgr.closing:
+				return
+			}
+		}
+	}()
+}
+
+// dispatch hands req over to push-goroutine of MBPU picked by pickDevice
+func (mgr *Manager) dispatch(req *requestWrapper) {
+	mpk := mgr.pickDevice(curveOf(req.env))
+	if mpk == nil {
+		// every mbpu is down
+		mgr.serveEmergency(req, ErrDeviceDown)
+		return
+	}
+
+	select {
+	case mpk.chDirect <- req:
+	case <-req.ctx.Done():
+		req.notify(ResponseEnvelop{result: -1, err: req.ctx.Err()})
+	case <-mgr.closing:
+		req.notify(ResponseEnvelop{result: -1, err: ErrManagerClosed})
+	}
+}
+
+// reroute dispatches req which MBPU went down without serving to the other MBPUs up, it does not block.
+// Requests are served without MBPU when every MBPU is down or they are out of retries.
+func (m *Mediumpk) reroute(req *requestWrapper) {
+	mgr := m.manager
+	if req.retries >= mgr.maxRetries {
+		mgr.serveEmergency(req, &DeviceError{m.index, ErrDeviceDown})
+		return
+	}
+	req.retries++
+	atomic.AddUint64(&m.stats[operationOf(req.env)].rerouted, 1)
+	go mgr.dispatch(req)
+}
+
+// devicesUp returns the number of MBPUs which are not down
+func (mgr *Manager) devicesUp() int {
+	n := 0
+	for _, mpk := range mgr.mpks {
+		if atomic.LoadInt32(&mpk.emergency) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// pickDevice returns the least loaded MBPU up computing curve, that is, the one with the least pending
+// requests per weight. MBPUs hotter than ThrottleTemperature are skipped unless every MBPU is that hot.
+// Ties are broken round robin. It returns nil if every MBPU computing curve is down.
+func (mgr *Manager) pickDevice(curve Curve) *Mediumpk {
+	allThrottled := true
+	for _, mpk := range mgr.mpks {
+		if mpk.curves.has(curve) && atomic.LoadInt32(&mpk.emergency) == 0 && atomic.LoadInt32(&mpk.throttled) == 0 {
+			allThrottled = false
+			break
+		}
+	}
+
+	var best *Mediumpk
+	var bestLoad float64
+	n := len(mgr.mpks)
+	start := int(atomic.AddUint32(&mgr.dispatchIndex, 1))
+	for i := 0; i < n; i++ {
+		mpk := mgr.mpks[(start+i)%n]
+		if !mpk.curves.has(curve) || atomic.LoadInt32(&mpk.emergency) == 1 {
+			continue
+		}
+		if !allThrottled && atomic.LoadInt32(&mpk.throttled) == 1 {
+			continue
+		}
+
+		pending := cap(mpk.slots) - len(mpk.slots)
+		load := float64(pending+1) / mpk.weight
+		if best == nil || load < bestLoad {
+			best, bestLoad = mpk, load
+		}
+	}
+	return best
+}
+
+// updateThrottle reads temperature of MBPUs and marks those hotter than ThrottleTemperature
+func (mgr *Manager) updateThrottle() {
+	for _, mpk := range mgr.mpks {
+		env, err := mpk.getMetric()
+		if err != nil {
+			continue
+		}
+		temperature := parseMetric(env.Temperature())
+
+		if temperature >= mgr.throttleTemperature {
+			if atomic.CompareAndSwapInt32(&mpk.throttled, 0, 1) {
+				mpk.logger.Warn("MBPU is throttled", "temperature", temperature)
+			}
+		} else if temperature < mgr.throttleTemperature-throttleHysteresis {
+			if atomic.CompareAndSwapInt32(&mpk.throttled, 1, 0) {
+				mpk.logger.Info("MBPU is not throttled any more", "temperature", temperature)
+			}
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package mediumpk
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// hotDevice is a SimulatorDevice reporting about 80 celsius
+type hotDevice struct {
+	*SimulatorDevice
+}
+
+func (d hotDevice) GetMetrics() ([]byte, error) {
+	buffer, err := d.SimulatorDevice.GetMetrics()
+	if err == nil {
+		binary.LittleEndian.PutUint32(buffer[0:4], 0xb497)
+	}
+	return buffer, err
+}
+
+// coolingDevice is a SimulatorDevice reporting about 80 celsius until cool is set
+type coolingDevice struct {
+	*SimulatorDevice
+	cool int32
+}
+
+func (d *coolingDevice) GetMetrics() ([]byte, error) {
+	if atomic.LoadInt32(&d.cool) == 1 {
+		return d.SimulatorDevice.GetMetrics()
+	}
+	return hotDevice{d.SimulatorDevice}.GetMetrics()
+}
+
+// takeSlots makes n slots of mpk pending as if requests were sent
+func takeSlots(mpk *Mediumpk, n int) {
+	for i := 0; i < n; i++ {
+		<-mpk.slots
+	}
+}
+
+// submitBlocked submits n requests made by newEnv, each on a goroutine of its own as Submit blocks while
+// slots are taken. Futures are sent to the returned channel once Submit returns.
+func submitBlocked(m *Manager, n int, newEnv func() RequestEnvelop) <-chan *Future {
+	futures := make(chan *Future, n)
+	for i := 0; i < n; i++ {
+		env := newEnv()
+		go func() {
+			futures <- m.Submit(context.Background(), env)
+		}()
+	}
+	return futures
+}
+
+// waitFutures waits for n futures of submitBlocked and asserts they succeed
+func waitFutures(t *testing.T, futures <-chan *Future, n int) {
+	for i := 0; i < n; i++ {
+		_, _, _, err := (<-futures).Wait()
+		assert.NoError(t, err)
+	}
+}
+
+func TestPickDevice(t *testing.T) {
+	m := newTestManager(t, Options{Devices: []Device{NewSimulatorDevice(), NewSimulatorDevice(), NewSimulatorDevice()}})
+
+	takeSlots(m.mpks[0], 3)
+	takeSlots(m.mpks[1], 1)
+	takeSlots(m.mpks[2], 2)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, 1, m.pickDevice(CurveP256).index)
+	}
+
+	// mbpu down is skipped
+	atomic.StoreInt32(&m.mpks[1].emergency, 1)
+	assert.Equal(t, 2, m.pickDevice(CurveP256).index)
+	atomic.StoreInt32(&m.mpks[0].emergency, 1)
+	atomic.StoreInt32(&m.mpks[2].emergency, 1)
+	assert.Nil(t, m.pickDevice(CurveP256))
+}
+
+func TestPickDevice_Weights(t *testing.T) {
+	m := newTestManager(t, Options{Devices: []Device{NewSimulatorDevice(), NewSimulatorDevice()}, Weights: []float64{1, 3}})
+
+	// (2+1)/1 > (5+1)/3
+	takeSlots(m.mpks[0], 2)
+	takeSlots(m.mpks[1], 5)
+	assert.Equal(t, 1, m.pickDevice(CurveP256).index)
+
+	// (2+1)/1 < (9+1)/3
+	takeSlots(m.mpks[1], 4)
+	assert.Equal(t, 0, m.pickDevice(CurveP256).index)
+}
+
+func TestPickDevice_Throttle(t *testing.T) {
+	m := newTestManager(t, Options{Devices: []Device{hotDevice{NewSimulatorDevice()}, NewSimulatorDevice()}, ThrottleTemperature: 70})
+
+	m.updateThrottle()
+
+	// cooler one is picked even though it is busier
+	takeSlots(m.mpks[1], 10)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&m.mpks[0].throttled))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&m.mpks[1].throttled))
+	assert.Equal(t, 1, m.pickDevice(CurveP256).index)
+
+	// hot one is used when the other is down
+	atomic.StoreInt32(&m.mpks[1].emergency, 1)
+	assert.Equal(t, 0, m.pickDevice(CurveP256).index)
+}
+
+func TestThrottle_SaturatedQueue(t *testing.T) {
+	dev := &coolingDevice{SimulatorDevice: NewSimulatorDevice()}
+	m := newTestManager(t, Options{Devices: []Device{dev}, MaxPending: 1, QueueDepth: 4, ThrottleTemperature: 70})
+	mpk := m.mpks[0]
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&mpk.throttled) == 1 }, time.Second, time.Millisecond)
+
+	// push-goroutine and dispatcher hold a request each waiting for the slot, and the queue is full
+	takeSlots(mpk, 1)
+	futures := submitBlocked(m, 6, func() RequestEnvelop { return newTestSignRequest(t) })
+	assert.Eventually(t, func() bool { return len(m.chanRequest[PriorityNormal]) == 4 }, time.Second, time.Millisecond)
+
+	atomic.StoreInt32(&dev.cool, 1)
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&mpk.throttled) == 0 }, 3*throttleInterval, 10*time.Millisecond)
+
+	mpk.slots <- true
+	waitFutures(t, futures, 6)
+}
+
+func TestNewManager_InvalidWeights(t *testing.T) {
+	_, err := NewManager(Options{Devices: []Device{NewSimulatorDevice()}, MaxPending: maxPending, Weights: []float64{1, 2}})
+	assert.Error(t, err)
+	_, err = NewManager(Options{Devices: []Device{NewSimulatorDevice()}, MaxPending: maxPending, Weights: []float64{0}})
+	assert.Error(t, err)
+}
+
+func TestDispatch(t *testing.T) {
+	m := newTestManager(t, Options{Devices: []Device{NewSimulatorDevice(), NewSimulatorDevice()}})
+
+	workload := data[0]
+	env, err := NewVerifyRequestEnvelop(workload.qx, workload.qy, workload.r, workload.s, workload.h)
+	assert.NoError(t, err)
+
+	// requests go to the idle one
+	takeSlots(m.mpks[0], maxPending/2)
+	for i := 0; i < 10; i++ {
+		_, _, _, err = m.Request(env)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, uint64(0), m.Stats()[0].Verify.Requests)
+	assert.Equal(t, uint64(10), m.Stats()[1].Verify.Requests)
+}
+
+func TestFailover(t *testing.T) {
+	dev := newStuckDevice()
+	m := newTestManager(t, Options{Devices: []Device{dev, NewSimulatorDevice()}, HealthCheckInterval: -1})
+	atomic.StoreInt32(&dev.stuck, 1)
+
+	reqs := make([]*requestWrapper, 3)
+	for i := range reqs {
+		reqs[i] = newRequestWrapper(context.Background(), newTestSignRequest(t))
+		m.mpks[0].chDirect <- reqs[i]
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&m.mpks[0].count) != 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	// requests pending on the failed one are served by the other one, not by fallback
+	m.mpks[0].markDown(errors.New("test"))
+	for _, req := range reqs {
+		result, _, _, err := req.wait()
+		assert.NoError(t, err)
+		assert.Equal(t, 0, result)
+	}
+	waitEmergency(t, m.mpks[0], 1)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&m.mpks[1].emergency))
+	assert.Equal(t, uint64(3), m.Stats()[1].Sign.Requests)
+	assert.Equal(t, uint64(3), m.Stats()[0].Sign.Rerouted)
+	_, count := m.cpuFallbackState()
+	assert.Equal(t, uint64(0), count)
+
+	// and so are new ones
+	_, _, _, err := m.Request(newTestSignRequest(t))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(4), m.Stats()[1].Sign.Requests)
+
+	// until every mbpu is down
+	m.mpks[1].markDown(errors.New("test"))
+	waitEmergency(t, m.mpks[1], 1)
+	_, _, _, err = m.Request(newTestSignRequest(t))
+	assert.True(t, errors.Is(err, ErrDeviceDown))
+}
+
+func TestFailover_WaitingSlot(t *testing.T) {
+	m := newTestManager(t, Options{Devices: []Device{NewSimulatorDevice(), NewSimulatorDevice()}, Weights: []float64{1000, 1}, HealthCheckInterval: -1})
+
+	// the failing one is still the least loaded with every slot taken
+	takeSlots(m.mpks[0], cap(m.mpks[0].slots))
+	req := newRequestWrapper(context.Background(), newTestSignRequest(t))
+	m.mpks[0].chDirect <- req
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadUint64(&m.mpks[0].stats[opSign].slotFull) != 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	m.mpks[0].markDown(errors.New("test"))
+	result, _, _, err := req.wait()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result)
+	assert.Equal(t, uint64(1), m.Stats()[0].Sign.Rerouted)
+	assert.Equal(t, uint64(1), m.Stats()[1].Sign.Requests)
+}
+
+func TestFailover_MaxRetries(t *testing.T) {
+	devs := []*stuckDevice{newStuckDevice(), newStuckDevice(), newStuckDevice()}
+	m := newTestManager(t, Options{Devices: []Device{devs[0], devs[1], devs[2]}, MaxRetries: 1, HealthCheckInterval: -1})
+	for _, dev := range devs {
+		atomic.StoreInt32(&dev.stuck, 1)
+	}
+
+	// waitPending waits until a request is pending on one of mbpus up and returns it
+	waitPending := func() *Mediumpk {
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			for _, mpk := range m.mpks {
+				if atomic.LoadInt32(&mpk.emergency) == 0 && atomic.LoadInt32(&mpk.count) == 1 {
+					return mpk
+				}
+			}
+			time.Sleep(time.Millisecond)
+		}
+		t.Fatal("request is not pending")
+		return nil
+	}
+
+	req := newRequestWrapper(context.Background(), newTestSignRequest(t))
+	m.mpks[0].chDirect <- req
+	waitPending()
+	m.mpks[0].markDown(errors.New("test"))
+	waitEmergency(t, m.mpks[0], 1)
+
+	// retried once, then given up although an mbpu is still up
+	mpk := waitPending()
+	mpk.markDown(errors.New("test"))
+	_, _, _, err := req.wait()
+	assert.True(t, errors.Is(err, ErrDeviceDown))
+	var devErr *DeviceError
+	assert.True(t, errors.As(err, &devErr))
+	assert.Equal(t, mpk.index, devErr.Index)
+	assert.Equal(t, 1, m.devicesUp())
+}
@@ -46,13 +46,21 @@ func hashToInt(hash []byte, c elliptic.Curve) *big.Int {
 	return ret
 }
 
-// CreateRandomK creates random k
+// CreateRandomK creates random k of P-256
 func CreateRandomK(d []byte, hash []byte) (k []byte, err error) {
+	return CreateRandomKCurve(CurveP256, d, hash)
+}
+
+// CreateRandomKCurve creates random k of curve c, which must not be secp256k1
+func CreateRandomKCurve(c Curve, d []byte, hash []byte) (k []byte, err error) {
+	if err = checkSignCurve(c); err != nil {
+		return nil, err
+	}
 	rand := rand.Reader
 	internal.MaybeReadByte(rand)
 
 	// Get min(log2(q) / 2, 256) bits of entropy from rand.
-	entropylen := (256 + 7) / 16
+	entropylen := (c.Elliptic().Params().BitSize + 7) / 16
 	if entropylen > 32 {
 		entropylen = 32
 	}
@@ -84,16 +92,20 @@ func CreateRandomK(d []byte, hash []byte) (k []byte, err error) {
 	}
 
 	// See [NSA] 3.4.1
-	c := elliptic.P256()
-	N := c.Params().N
+	curve := c.Elliptic()
+	N := curve.Params().N
 	if N.Sign() == 0 {
 		return nil, errors.New("zero parameter")
 	}
 
-	return internal.RandFieldElement(c, csprng)
+	return internal.RandFieldElement(curve, csprng)
 }
 
+// SignCPU signs hash with priv and k on CPU. Keys of secp256k1 are refused, see checkSignCurve.
 func SignCPU(priv *ecdsa.PrivateKey, k *big.Int, c elliptic.Curve, hash []byte) (r, s *big.Int, err error) {
+	if c == secp256k1 || priv.Curve == secp256k1 {
+		return nil, nil, checkSignCurve(CurveSecp256k1)
+	}
 	N := c.Params().N
 	if N.Sign() == 0 {
 		return nil, nil, errZeroParam
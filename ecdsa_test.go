@@ -4,6 +4,7 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"errors"
 	"math/big"
 	"testing"
 
@@ -13,8 +14,10 @@ import (
 func testSignAndVerify(t *testing.T, c elliptic.Curve, tag string) {
 	priv, _ := ecdsa.GenerateKey(c, rand.Reader)
 
+	curve, err := CurveOf(c)
+	assert.NoError(t, err)
 	hashed := []byte("testing")
-	randomK, err := CreateRandomK(priv.D.Bytes(), hashed)
+	randomK, err := CreateRandomKCurve(curve, priv.D.Bytes(), hashed)
 	assert.NoError(t, err)
 	k := new(big.Int)
 	k.SetBytes(randomK)
@@ -43,3 +46,23 @@ func TestSignAndVerify(t *testing.T) {
 	testSignAndVerify(t, elliptic.P384(), "p384")
 	testSignAndVerify(t, elliptic.P521(), "p521")
 }
+
+func TestSignCPU_Secp256k1(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(CurveSecp256k1.Elliptic(), rand.Reader)
+	assert.NoError(t, err)
+	hashed := []byte("testing")
+
+	_, err = CreateRandomKCurve(CurveSecp256k1, priv.D.Bytes(), hashed)
+	assert.True(t, errors.Is(err, ErrUnsupportedCurve))
+	_, _, err = SignCPU(priv, big.NewInt(1), priv.Curve, hashed)
+	assert.True(t, errors.Is(err, ErrUnsupportedCurve))
+	_, err = NewCurveSignRequestEnvelop(CurveSecp256k1, priv.D.Bytes(), priv.D.Bytes(), hashed)
+	assert.True(t, errors.Is(err, ErrInvalidEnvelop))
+
+	// verifying is supported
+	r, s, err := ecdsa.Sign(rand.Reader, priv, hashed)
+	assert.NoError(t, err)
+	assert.True(t, VerifyCPU(&priv.PublicKey, hashed, r, s))
+	hashed[0] ^= 0xff
+	assert.False(t, VerifyCPU(&priv.PublicKey, hashed, r, s))
+}
@@ -1,41 +1,226 @@
 package mediumpk
 
+import (
+	"fmt"
+	"math/big"
+)
+
+// operation is the type of request
+type operation int
+
+const (
+	opSign operation = iota
+	opVerify
+)
+
+func (o operation) String() string {
+	if o == opVerify {
+		return "verify"
+	}
+	return "sign"
+}
+
 // RequestEnvelop is the interface for sending requst to FPGA
 type RequestEnvelop interface {
-	Bytes(serializer, int) []byte
+	Bytes(serializer, int) ([]byte, error)
+	Validate() error
+	// canonical returns the envelope with fields padded as the NewCurve*RequestEnvelop functions do
+	canonical() (RequestEnvelop, error)
+}
+
+// operationOf returns operation type of env
+func operationOf(env RequestEnvelop) operation {
+	if _, ok := env.(VerifyRequestEnvelop); ok {
+		return opVerify
+	}
+	return opSign
+}
+
+// curveOf returns curve of env
+func curveOf(env RequestEnvelop) Curve {
+	switch env := env.(type) {
+	case SignRequestEnvelop:
+		return env.Curve
+	case VerifyRequestEnvelop:
+		return env.Curve
+	}
+	return CurveP256
 }
 
-// SignRequestEnvelop is a structure for Sign Generation Request
+// SignRequestEnvelop is a structure for Sign Generation Request, of P-256 unless Curve is set
 type SignRequestEnvelop struct {
-	D []byte
-	K []byte
-	H []byte
+	D     []byte
+	K     []byte
+	H     []byte
+	Curve Curve // zero value is P-256
+}
+
+// NewSignRequestEnvelop returns P-256 SignRequestEnvelop with d, k, h left-padded to 32 bytes.
+// d and k must be in [1, N-1] of P-256 and h must not be longer than 32 bytes.
+func NewSignRequestEnvelop(d, k, h []byte) (SignRequestEnvelop, error) {
+	return NewCurveSignRequestEnvelop(CurveP256, d, k, h)
+}
+
+// NewCurveSignRequestEnvelop returns SignRequestEnvelop of curve c with d, k, h left-padded to the size of c.
+// d and k must be in [1, N-1] of c and h must not be longer than the size of c. c must not be secp256k1.
+func NewCurveSignRequestEnvelop(c Curve, d, k, h []byte) (env SignRequestEnvelop, err error) {
+	if err = checkSignCurve(c); err != nil {
+		return SignRequestEnvelop{}, fmt.Errorf("%w: %v", ErrInvalidEnvelop, err)
+	}
+	env.Curve = c
+	if env.D, err = canonicalScalar(c, "d", d); err != nil {
+		return SignRequestEnvelop{}, err
+	}
+	if env.K, err = canonicalScalar(c, "k", k); err != nil {
+		return SignRequestEnvelop{}, err
+	}
+	if env.H, err = canonicalHash(c, "h", h); err != nil {
+		return SignRequestEnvelop{}, err
+	}
+	return env, nil
 }
 
 // Bytes copies value of SignRequestEnvelop into aligned memory
-func (req SignRequestEnvelop) Bytes(s serializer, userctx int) []byte {
+func (req SignRequestEnvelop) Bytes(s serializer, userctx int) ([]byte, error) {
 	return s.serializeSignRequest(req, userctx)
 }
 
-// VerifyRequestEnvelop is a structure for Sign Verification Request
+// Validate checks if SignRequestEnvelop can be serialized
+func (req SignRequestEnvelop) Validate() error {
+	_, err := req.canonical()
+	return err
+}
+
+func (req SignRequestEnvelop) canonical() (RequestEnvelop, error) {
+	return NewCurveSignRequestEnvelop(req.Curve, req.D, req.K, req.H)
+}
+
+// VerifyRequestEnvelop is a structure for Sign Verification Request, of P-256 unless Curve is set
 type VerifyRequestEnvelop struct {
-	Qx []byte
-	Qy []byte
-	R  []byte
-	S  []byte
-	H  []byte
+	Qx    []byte
+	Qy    []byte
+	R     []byte
+	S     []byte
+	H     []byte
+	Curve Curve // zero value is P-256
+}
+
+// NewVerifyRequestEnvelop returns P-256 VerifyRequestEnvelop with qx, qy, r, s, h left-padded to 32 bytes.
+// qx and qy must be less than P, r and s must be in [1, N-1] of P-256 and h must not be longer than 32 bytes.
+func NewVerifyRequestEnvelop(qx, qy, r, s, h []byte) (VerifyRequestEnvelop, error) {
+	return NewCurveVerifyRequestEnvelop(CurveP256, qx, qy, r, s, h)
+}
+
+// NewCurveVerifyRequestEnvelop returns VerifyRequestEnvelop of curve c with qx, qy, r, s, h left-padded
+// to the size of c. qx and qy must be less than P, r and s must be in [1, N-1] of c and h must not be
+// longer than the size of c.
+func NewCurveVerifyRequestEnvelop(c Curve, qx, qy, r, s, h []byte) (env VerifyRequestEnvelop, err error) {
+	if err = checkCurve(c); err != nil {
+		return VerifyRequestEnvelop{}, fmt.Errorf("%w: %v", ErrInvalidEnvelop, err)
+	}
+	env.Curve = c
+	if env.Qx, err = canonicalCoordinate(c, "qx", qx); err != nil {
+		return VerifyRequestEnvelop{}, err
+	}
+	if env.Qy, err = canonicalCoordinate(c, "qy", qy); err != nil {
+		return VerifyRequestEnvelop{}, err
+	}
+	if env.R, err = canonicalScalar(c, "r", r); err != nil {
+		return VerifyRequestEnvelop{}, err
+	}
+	if env.S, err = canonicalScalar(c, "s", s); err != nil {
+		return VerifyRequestEnvelop{}, err
+	}
+	if env.H, err = canonicalHash(c, "h", h); err != nil {
+		return VerifyRequestEnvelop{}, err
+	}
+	return env, nil
 }
 
 // Bytes copies value of VerifyRequestEnvelop into aligned memory
-func (req VerifyRequestEnvelop) Bytes(s serializer, userctx int) []byte {
+func (req VerifyRequestEnvelop) Bytes(s serializer, userctx int) ([]byte, error) {
 	return s.serializeVerifyRequest(req, userctx)
 }
 
+// Validate checks if VerifyRequestEnvelop can be serialized
+func (req VerifyRequestEnvelop) Validate() error {
+	_, err := req.canonical()
+	return err
+}
+
+func (req VerifyRequestEnvelop) canonical() (RequestEnvelop, error) {
+	return NewCurveVerifyRequestEnvelop(req.Curve, req.Qx, req.Qy, req.R, req.S, req.H)
+}
+
+// canonicalField returns b left-padded to the size of c
+func canonicalField(c Curve, name string, b []byte) ([]byte, error) {
+	padded, err := leftPad(b, c.size())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s %s", ErrInvalidEnvelop, name, err.Error())
+	}
+	return padded, nil
+}
+
+// canonicalHash returns hash b as digest of the size of c which ecdsa takes as the same integer as b.
+// It is b left-padded unless the bit length of the order of c is not a multiple of 8, P-521, whose
+// digest has the bits ecdsa drops cleared. canonicalHash of its result is the result itself,
+// and the result is the integer MBPU takes for curves fitting in frame.
+func canonicalHash(c Curve, name string, b []byte) ([]byte, error) {
+	if _, err := canonicalField(c, name, b); err != nil {
+		return nil, err
+	}
+	return digestOf(c, hashToInt(b, c.Elliptic()))
+}
+
+// digestOf returns digest of the size of c which ecdsa takes as integer h
+func digestOf(c Curve, h *big.Int) ([]byte, error) {
+	excess := c.size()*8 - c.Elliptic().Params().BitSize
+	digest, err := leftPad(new(big.Int).Lsh(h, uint(excess)).Bytes(), c.size())
+	if err != nil {
+		return nil, fmt.Errorf("%w: h %s", ErrInvalidEnvelop, err.Error())
+	}
+	return digest, nil
+}
+
+// canonicalScalar returns b left-padded to the size of c, b must be in [1, N-1]
+func canonicalScalar(c Curve, name string, b []byte) ([]byte, error) {
+	padded, err := canonicalField(c, name, b)
+	if err != nil {
+		return nil, err
+	}
+	v := new(big.Int).SetBytes(padded)
+	if v.Sign() == 0 || v.Cmp(c.Elliptic().Params().N) >= 0 {
+		return nil, fmt.Errorf("%w: %s is out of range", ErrInvalidEnvelop, name)
+	}
+	return padded, nil
+}
+
+// canonicalCoordinate returns b left-padded to the size of c, b must be less than P
+func canonicalCoordinate(c Curve, name string, b []byte) ([]byte, error) {
+	padded, err := canonicalField(c, name, b)
+	if err != nil {
+		return nil, err
+	}
+	if new(big.Int).SetBytes(padded).Cmp(c.Elliptic().Params().P) >= 0 {
+		return nil, fmt.Errorf("%w: %s is out of range", ErrInvalidEnvelop, name)
+	}
+	return padded, nil
+}
+
+// result codes of ResponseEnvelop
+const (
+	resultOK      = 0
+	resultInvalid = 1
+	resultError   = 2
+)
+
 // ResponseEnvelop is the interface to receive respose from FPGA
 type ResponseEnvelop struct {
+	op     operation // told by header
 	result int
 	r      []byte
 	s      []byte
+	err    error
 }
 
 // Deserialize fill ResponseEnvelop with data from buffer and return userctx
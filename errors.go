@@ -0,0 +1,76 @@
+/*
+Copyright Medium Corp. 2020 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package mediumpk
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrDeviceDown is returned when MBPU is down and the request can not be served
+	ErrDeviceDown = errors.New("mbpu device is down")
+	// ErrQueueFull is returned when there is no room for the request
+	ErrQueueFull = errors.New("mbpu queue is full")
+	// ErrVerifyFailed is returned when MBPU rejects the signature of verify request
+	ErrVerifyFailed = errors.New("signature verification failed")
+	// ErrSignFailed is returned when MBPU fails to generate the signature of sign request
+	ErrSignFailed = errors.New("signature generation failed")
+	// ErrManagerClosed is returned when mbpu manager is not initialized or already closed
+	ErrManagerClosed = errors.New("mbpu manager is closed")
+	// ErrMalformedResponse is returned when response from MBPU can not be parsed
+	ErrMalformedResponse = errors.New("malformed response from mbpu")
+	// ErrInvalidEnvelop is returned when request envelop has malformed or out of range field
+	ErrInvalidEnvelop = errors.New("invalid request envelop")
+	// ErrSelfTestFailed is returned when MBPU gives wrong answer to known-answer test
+	ErrSelfTestFailed = errors.New("mbpu self-test failed")
+	// ErrInconsistentSignature is returned when signature generated by MBPU does not verify
+	ErrInconsistentSignature = errors.New("signature from mbpu does not verify")
+	// ErrDeviceStuck is returned when MBPU does not answer request in Options.RequestTimeout
+	ErrDeviceStuck = errors.New("mbpu device is stuck")
+	// ErrInvalidSignature is returned when signature can not be decoded
+	ErrInvalidSignature = errors.New("invalid signature encoding")
+	// ErrInvalidPublicKey is returned when public key is not a point of its curve
+	ErrInvalidPublicKey = errors.New("invalid public key")
+	// ErrUnsupportedCurve is returned when curve is none of P-224, P-256, P-384, P-521 and secp256k1,
+	// or when signing with secp256k1 which is supported for verification only
+	ErrUnsupportedCurve = errors.New("unsupported curve")
+)
+
+// DeviceError records an error and the index of MBPU that caused it
+type DeviceError struct {
+	Index int
+	Err   error
+}
+
+func (e *DeviceError) Error() string {
+	return fmt.Sprintf("mbpu%d: %s", e.Index, e.Err.Error())
+}
+
+// Unwrap returns the underlying error
+func (e *DeviceError) Unwrap() error {
+	return e.Err
+}
+
+// ResultError records non-zero result code returned by MBPU.
+// It unwraps to ErrSignFailed or ErrVerifyFailed according to the operation.
+type ResultError struct {
+	Op     string
+	Result int
+}
+
+func (e *ResultError) Error() string {
+	return fmt.Sprintf("%s request returned result %d", e.Op, e.Result)
+}
+
+// Unwrap returns ErrVerifyFailed for verify request and ErrSignFailed for sign request
+func (e *ResultError) Unwrap() error {
+	if e.Op == opVerify.String() {
+		return ErrVerifyFailed
+	}
+	return ErrSignFailed
+}
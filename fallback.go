@@ -0,0 +1,133 @@
+/*
+Copyright Medium Corp. 2020 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package mediumpk
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"runtime"
+	"sync/atomic"
+)
+
+// SetCPUFallback turns CPU fallback on or off.
+// When it is on, requests are computed on CPU with SignCPU/VerifyCPU once MBPU is declared down,
+// instead of failing with ErrDeviceDown.
+func (m *Manager) SetCPUFallback(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&m.cpuFallback, v)
+}
+
+// cpuFallbackState returns whether CPU fallback is on and the number of requests computed on CPU
+func (mgr *Manager) cpuFallbackState() (int, uint64) {
+	return int(atomic.LoadInt32(&mgr.cpuFallback)), atomic.LoadUint64(&mgr.fallbackCount)
+}
+
+// serveEmergency answers req while MBPU is down, on CPU if fallback is on or with err otherwise
+func (mgr *Manager) serveEmergency(req *requestWrapper, err error) {
+	if req.ctx.Err() != nil {
+		// requester gave up
+		req.notify(ResponseEnvelop{result: -1, err: req.ctx.Err()})
+		return
+	}
+
+	if atomic.LoadInt32(&mgr.cpuFallback) == 0 {
+		req.notify(ResponseEnvelop{result: -1, err: err})
+		return
+	}
+
+	atomic.AddUint64(&mgr.fallbackCount, 1)
+	mgr.computeOnCPU(req)
+}
+
+// runCPUWorkers runs as many cpu-goroutines as CPUs, computing requests served on CPU until shutdown is over.
+// They take requests of MBPUs down and requests of curve no MBPU computes queued in cpuQueue.
+func (mgr *Manager) runCPUWorkers() {
+	for i := 0; i < runtime.NumCPU(); i++ {
+		go func() {
+			for {
+				select {
+				case req := <-mgr.cpuRequests:
+					req.accept()
+					req.notify(computeCPU(req.env))
+				case req := <-mgr.cpuQueue:
+					req.accept()
+					if req.ctx.Err() != nil {
+						// requester gave up while queueing
+						req.notify(ResponseEnvelop{result: -1, err: req.ctx.Err()})
+						continue
+					}
+					req.notify(computeCPU(req.env))
+				case <-mgr.done:
+					return
+				}
+			}
+		}()
+	}
+}
+
+// computeOnCPU hands req of MBPU down over to cpu-goroutines. It blocks while they are busy, so that
+// dispatcher stops taking requests and the admission queue fills up as it does when MBPUs are busy.
+func (mgr *Manager) computeOnCPU(req *requestWrapper) {
+	select {
+	case mgr.cpuRequests <- req:
+	case <-req.ctx.Done():
+		req.notify(ResponseEnvelop{result: -1, err: req.ctx.Err()})
+	case <-mgr.abort:
+		req.notify(ResponseEnvelop{result: -1, err: ErrManagerClosed})
+	case <-mgr.done:
+		req.notify(ResponseEnvelop{result: -1, err: ErrManagerClosed})
+	}
+}
+
+// computeCPU computes env on CPU and returns response with the same result/r/s semantics as MBPU
+func computeCPU(env RequestEnvelop) ResponseEnvelop {
+	curve := curveOf(env)
+	if checkCurve(curve) != nil {
+		return ResponseEnvelop{result: -1, err: ErrUnsupportedCurve}
+	}
+	c := curve.Elliptic()
+	size := curve.size()
+	// h of canonical envelope is digest ecdsa takes
+	env, err := env.canonical()
+	if err != nil {
+		return ResponseEnvelop{result: -1, err: err}
+	}
+
+	switch env := env.(type) {
+	case SignRequestEnvelop:
+		priv := &ecdsa.PrivateKey{
+			PublicKey: ecdsa.PublicKey{Curve: c},
+			D:         new(big.Int).SetBytes(env.D),
+		}
+		r, s, err := SignCPU(priv, new(big.Int).SetBytes(env.K), c, env.H)
+		if err != nil {
+			return ResponseEnvelop{result: resultError, r: make([]byte, size), s: make([]byte, size)}
+		}
+
+		res := ResponseEnvelop{result: resultOK, r: make([]byte, size), s: make([]byte, size)}
+		rBytes, sBytes := r.Bytes(), s.Bytes()
+		copy(res.r[size-len(rBytes):], rBytes)
+		copy(res.s[size-len(sBytes):], sBytes)
+		return res
+	case VerifyRequestEnvelop:
+		pub := &ecdsa.PublicKey{
+			Curve: c,
+			X:     new(big.Int).SetBytes(env.Qx),
+			Y:     new(big.Int).SetBytes(env.Qy),
+		}
+		res := ResponseEnvelop{result: resultOK, r: make([]byte, size), s: make([]byte, size)}
+		if !c.IsOnCurve(pub.X, pub.Y) || !VerifyCPU(pub, env.H, new(big.Int).SetBytes(env.R), new(big.Int).SetBytes(env.S)) {
+			res.result = resultInvalid
+		}
+		return res
+	}
+
+	return ResponseEnvelop{result: -1, err: ErrDeviceDown}
+}
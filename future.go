@@ -0,0 +1,47 @@
+/*
+Copyright Medium Corp. 2020 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package mediumpk
+
+import (
+	"context"
+)
+
+// Future is a request submitted by Submit whose response may not have arrived yet
+type Future struct {
+	req *requestWrapper
+}
+
+// Submit sends env to MBPU and returns Future without waiting for response, so that a single goroutine
+// can keep many requests in flight. It returns once env is accepted into a slot of MBPU, or taken by
+// cpu-goroutine if it is computed on CPU, and blocks while they are busy until ctx is done or m is closed.
+// It never fails with ErrQueueFull, use TryRequest to shed load instead.
+// No error is returned by Submit itself: invalid env, ctx done and ErrManagerClosed resolve the Future,
+// whose Wait returns them as well as errors of computing the request.
+func (m *Manager) Submit(ctx context.Context, env RequestEnvelop) *Future {
+	req, err := m.submit(ctx, env)
+	if err != nil {
+		req = newRequestWrapper(ctx, env)
+		req.notify(ResponseEnvelop{result: -1, err: err})
+	}
+	select {
+	case <-req.accepted:
+	case <-ctx.Done():
+		// Wait returns ctx.Err()
+	}
+	return &Future{req}
+}
+
+// Done returns a channel that is closed when response has arrived
+func (f *Future) Done() <-chan struct{} {
+	return f.req.done
+}
+
+// Wait blocks until response arrives or ctx given to Submit is done, and returns
+// the same values as Request
+func (f *Future) Wait() (int, []byte, []byte, error) {
+	return f.req.wait()
+}
@@ -0,0 +1,63 @@
+package mediumpk
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubmit(t *testing.T) {
+	futures := make([]*Future, maxPending*2)
+	for i := range futures {
+		workload := data[i]
+		env, err := NewVerifyRequestEnvelop(workload.qx, workload.qy, workload.r, workload.s, workload.h)
+		assert.NoError(t, err)
+		futures[i] = Submit(context.Background(), env)
+	}
+
+	for _, f := range futures {
+		<-f.Done()
+		result, _, _, err := f.Wait()
+		assert.NoError(t, err)
+		assert.Equal(t, 0, result)
+	}
+}
+
+func TestSubmit_Invalid(t *testing.T) {
+	f := Submit(context.Background(), SignRequestEnvelop{})
+
+	select {
+	case <-f.Done():
+	default:
+		t.Fatal("future of invalid envelop must be done")
+	}
+	_, _, _, err := f.Wait()
+	assert.True(t, errors.Is(err, ErrInvalidEnvelop))
+}
+
+func TestSubmit_Accepted(t *testing.T) {
+	m := newTestManager(t, Options{Devices: []Device{NewSimulatorDevice()}, MaxPending: 1})
+
+	// Submit returns once the request is taken into a slot
+	takeSlots(m.mpks[0], 1)
+	futures := submitBlocked(m, 1, func() RequestEnvelop { return newTestSignRequest(t) })
+	select {
+	case <-futures:
+		t.Fatal("request is submitted while every slot is taken")
+	case <-time.After(50 * time.Millisecond):
+	}
+	m.mpks[0].slots <- true
+	waitFutures(t, futures, 1)
+
+	// or once ctx is done
+	takeSlots(m.mpks[0], 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	f := m.Submit(ctx, newTestSignRequest(t))
+	_, _, _, err := f.Wait()
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	m.mpks[0].slots <- true
+}
@@ -0,0 +1,249 @@
+/*
+Copyright Medium Corp. 2020 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package mediumpk
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultHealthCheckInterval is the interval of health check of MBPU down
+	defaultHealthCheckInterval = 10 * time.Second
+	// katTimeout is how long known-answer test waits for response
+	katTimeout = time.Second
+	// katUserctx is userctx of known-answer request, out of range of slots
+	katUserctx = 0xabc
+)
+
+// known-answer vector, the same key/nonce/hash FPGADevice.Reset signs
+var (
+	katD, _  = hex.DecodeString("7d249da772445811b772c26454a6308d6495726cd9c3bb2085245f1a2fdeb7fb")
+	katK, _  = hex.DecodeString("d6b6f6b9bad35bd164a0a5727b34f18a689663326d4572c18d78bd153cc923ff")
+	katH, _  = hex.DecodeString("a591a6d40bf420404a011733cfb7b190d62c65bf0bcda32b57b277d9ad9f146e")
+	katQx, _ = hex.DecodeString("66c57ad8539f4cd822e6963d5102787950ff9be94db64610402ffe02b9b3c2e3")
+	katQy, _ = hex.DecodeString("58955255b782fee0024ed602fd30b2cc115d7aba93283f803e0e085f60e508da")
+	katR, _  = hex.DecodeString("0a2ee1139a81c762bc813cdc4dc135116453510eb07158a9ee0eb436fe5130d3")
+	katS, _  = hex.DecodeString("3bcd498a1cc4d3163f50b08c2aa2a01ed708ce93fb14e872cd7a9f41b79a5128")
+)
+
+// katVector is a known-answer vector of a curve, d and k are nil for curves which are only verified
+type katVector struct {
+	d, k, h, qx, qy, r, s []byte
+}
+
+// katVectors are known-answer vectors of curves MBPU may compute. The others are made of the P-256
+// vector truncated to the size of curve.
+var katVectors = map[Curve]katVector{
+	CurveP256: {katD, katK, katH, katQx, katQy, katR, katS},
+	CurveP224: {
+		d:  katHex("7d249da772445811b772c26454a6308d6495726cd9c3bb2085245f1a"),
+		k:  katHex("d6b6f6b9bad35bd164a0a5727b34f18a689663326d4572c18d78bd15"),
+		h:  katHex("a591a6d40bf420404a011733cfb7b190d62c65bf0bcda32b57b277d9"),
+		qx: katHex("68fff64f9aaad80ef8a27f7f62cfdb8a2f95128bd3c453174cd33f08"),
+		qy: katHex("0ae9d79fe8f170e4050b25ddb816c23b4b41325da46f7c50f3d2d371"),
+		r:  katHex("1c1330b214f8fb674b7b26e532794e1d249b8214ab25d6bd32b5d4d6"),
+		s:  katHex("7064843968bcbb39522f2057062039a006a6e6e85c7392392c66cfcf"),
+	},
+	CurveSecp256k1: {
+		h:  katH,
+		qx: katHex("69fe161ef3a11b3cbdb61acc5442315a5a1993910973e10747430dfdd6dbc9b5"),
+		qy: katHex("435cc8788a69d53f5d6b8008c89e8a1f16f81bf376efee334a62d23db14c088e"),
+		r:  katHex("bc56361de831e94699881064c9761871da61e24246c9af1298001d91e63e2844"),
+		s:  katHex("479ae365d205ea01fb7b10f93cc7cbb24cead95d3cb320bf8c317f28f2dc1b50"),
+	},
+}
+
+func katHex(s string) []byte {
+	b, _ := hex.DecodeString(s)
+	return b
+}
+
+// checkHealth tells whether dev which is not used by push/poll-goroutines is able to serve requests.
+// It checks channels, version and self-test, resetting dev in between.
+// The returned channel is closed when nobody polls dev any more, which is later than return
+// if self-test timed out.
+func checkHealth(dev Device) (<-chan struct{}, error) {
+	if checker, ok := dev.(AvailabilityChecker); ok {
+		if err := checker.CheckAvailable(); err != nil {
+			return closedChan, err
+		}
+	}
+	if _, err := dev.Version(); err != nil {
+		return closedChan, err
+	}
+	if err := dev.Reset(); err != nil {
+		return closedChan, err
+	}
+	return selfTest(dev)
+}
+
+// closedChan is returned as done channel of what is already done
+var closedChan = func() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}()
+
+// selfTest runs known-answer tests of P-256 on dev, see selfTestCurve
+func selfTest(dev Device) (<-chan struct{}, error) {
+	return selfTestCurve(dev, CurveP256)
+}
+
+// selfTestCurve runs known-answer tests of curve c on dev: signing the known-answer vector must give
+// the expected signature, verifying it must succeed and verifying it corrupted must fail. Counters
+// touched by the tests are cleared by Reset. The returned channel is closed when polling dev is over.
+func selfTestCurve(dev Device, c Curve) (<-chan struct{}, error) {
+	v := katVectors[c]
+	corruptedS := make([]byte, len(v.s))
+	copy(corruptedS, v.s)
+	corruptedS[len(corruptedS)-1] ^= 0x01
+
+	type katTest struct {
+		name string
+		env  RequestEnvelop
+		ok   bool // whether result must be resultOK, any other result is a failure
+	}
+	var tests []katTest
+	if v.d != nil {
+		tests = append(tests, katTest{"sign", SignRequestEnvelop{v.d, v.k, v.h, c}, true})
+	}
+	tests = append(tests,
+		katTest{"verify", VerifyRequestEnvelop{v.qx, v.qy, v.r, v.s, v.h, c}, true},
+		katTest{"verify corrupted", VerifyRequestEnvelop{v.qx, v.qy, v.r, corruptedS, v.h, c}, false},
+	)
+	for _, test := range tests {
+		pollDone, resEnv, err := knownAnswer(dev, test.env)
+		if err != nil {
+			return pollDone, fmt.Errorf("%w: %v %s: %v", ErrSelfTestFailed, c, test.name, err)
+		}
+		if (resEnv.Result() == resultOK) != test.ok {
+			return pollDone, fmt.Errorf("%w: %v %s: unexpected result %d", ErrSelfTestFailed, c, test.name, resEnv.Result())
+		}
+		if _, ok := test.env.(SignRequestEnvelop); ok {
+			// r and s of response are as long as frame fields, which may be longer than those of c
+			r, s := resEnv.Signature()
+			if !equalInt(r, v.r) || !equalInt(s, v.s) {
+				return pollDone, fmt.Errorf("%w: %v %s: signature mismatch", ErrSelfTestFailed, c, test.name)
+			}
+		}
+	}
+
+	if err := dev.Reset(); err != nil {
+		return closedChan, err
+	}
+	return closedChan, nil
+}
+
+// equalInt tells whether big-endian integers a and b are equal
+func equalInt(a, b []byte) bool {
+	return new(big.Int).SetBytes(a).Cmp(new(big.Int).SetBytes(b)) == 0
+}
+
+// knownAnswer sends env to dev and returns its response, waiting at most katTimeout.
+// The returned channel is closed when polling the response is over.
+func knownAnswer(dev Device, env RequestEnvelop) (<-chan struct{}, ResponseEnvelop, error) {
+	var resEnv ResponseEnvelop
+	buffer, err := env.Bytes(serializer{}, katUserctx)
+	if err != nil {
+		return closedChan, resEnv, err
+	}
+	if err := dev.Request(buffer); err != nil {
+		return closedChan, resEnv, err
+	}
+
+	pollDone := make(chan struct{})
+	var pollErr error
+	go func() {
+		defer close(pollDone)
+		buffer, pollErr = dev.Poll()
+	}()
+
+	select {
+	case <-pollDone:
+	case <-time.After(katTimeout):
+		return pollDone, resEnv, fmt.Errorf("no response in %v", katTimeout)
+	}
+	if pollErr != nil {
+		return pollDone, resEnv, pollErr
+	}
+
+	userctx, err := resEnv.Deserialize(deserializer{}, buffer)
+	if err != nil {
+		return pollDone, resEnv, err
+	}
+	if op := operationOf(env); resEnv.op != op {
+		return pollDone, resEnv, fmt.Errorf("%w: %s response to %s request", ErrMalformedResponse, resEnv.op, op)
+	}
+	if userctx != katUserctx {
+		return pollDone, resEnv, fmt.Errorf("%w: userctx %d", ErrMalformedResponse, userctx)
+	}
+	return pollDone, resEnv, nil
+}
+
+// waitRecovery waits until MBPU which is down passes health check, serving requests without MBPU meanwhile.
+// Health check starts after pollDone is closed, that is, nobody polls device.
+// It returns true when MBPU is re-admitted, false when manager is closed or recovery is disabled.
+func (m *Mediumpk) waitRecovery(pollDone <-chan struct{}) bool {
+	mgr := m.manager
+	stop := make(chan struct{})
+	emergencyDone := make(chan struct{})
+	go func() {
+		defer close(emergencyDone)
+		m.runEmergency(stop)
+	}()
+	// requests must not be served without MBPU after it is re-admitted
+	defer func() {
+		close(stop)
+		<-emergencyDone
+	}()
+
+	if mgr.healthCheckInterval < 0 {
+		<-mgr.closing
+		return false
+	}
+
+	ticker := time.NewTicker(mgr.healthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-mgr.closing:
+			return false
+		case <-ticker.C:
+		}
+
+		select {
+		case <-pollDone:
+		default:
+			// polling-goroutine or known-answer test is still blocked on device
+			continue
+		}
+
+		var err error
+		if pollDone, err = checkHealth(m.dev); err != nil {
+			m.logger.Debug("MBPU is still down", "error", err)
+			continue
+		}
+
+		// every pending request was answered by clearChanStore, give their slots back
+		for len(m.slots) < cap(m.slots) {
+			m.slots <- true
+		}
+		m.storeLock.Lock()
+		for i := range m.chanStore {
+			m.chanStore[i] = nil
+		}
+		m.storeLock.Unlock()
+		atomic.StoreInt32(&m.count, 0)
+
+		m.logger.Info("MBPU recovered")
+		return true
+	}
+}
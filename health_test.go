@@ -0,0 +1,114 @@
+package mediumpk
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckHealth(t *testing.T) {
+	dev := NewSimulatorDevice()
+	defer dev.Close()
+
+	done, err := checkHealth(dev)
+	assert.NoError(t, err)
+	<-done
+
+	_, err = checkHealth(faultyDevice{dev})
+	assert.Error(t, err)
+
+	dev.Close()
+	_, err = checkHealth(dev)
+	assert.Error(t, err)
+}
+
+func TestRecovery(t *testing.T) {
+	dev := &flakyDevice{SimulatorDevice: NewSimulatorDevice()}
+	m := newTestManager(t, Options{Devices: []Device{dev}, HealthCheckInterval: 10 * time.Millisecond})
+	atomic.StoreInt32(&dev.broken, 1)
+
+	workload := data[0]
+	env, err := NewVerifyRequestEnvelop(workload.qx, workload.qy, workload.r, workload.s, workload.h)
+	assert.NoError(t, err)
+
+	_, _, _, err = m.Request(env)
+	assert.True(t, errors.Is(err, ErrDeviceDown))
+
+	// still down while device is broken
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&m.mpks[0].emergency))
+
+	atomic.StoreInt32(&dev.broken, 0)
+	waitEmergency(t, m.mpks[0], 0)
+
+	for i := 0; i < maxPending*2; i++ {
+		result, _, _, err := m.Request(env)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, result)
+	}
+	assert.Equal(t, uint64(maxPending*2), m.Stats()[0].Verify.Requests)
+}
+
+// wrongDevice is a SimulatorDevice returning wrong signatures unless correct is set
+type wrongDevice struct {
+	*SimulatorDevice
+	correct int32
+}
+
+func (d *wrongDevice) Poll() ([]byte, error) {
+	buffer, err := d.SimulatorDevice.Poll()
+	if err == nil && atomic.LoadInt32(&d.correct) == 0 {
+		// last byte of r
+		buffer[47] ^= 0x01
+	}
+	return buffer, err
+}
+
+// errorCodeDevice is a SimulatorDevice reporting signatures which do not verify with resultError
+type errorCodeDevice struct {
+	*SimulatorDevice
+}
+
+func (d errorCodeDevice) Poll() ([]byte, error) {
+	buffer, err := d.SimulatorDevice.Poll()
+	if err == nil && binary.BigEndian.Uint32(buffer[4:8]) == resultInvalid {
+		binary.BigEndian.PutUint32(buffer[4:8], resultError)
+	}
+	return buffer, err
+}
+
+func TestSelfTest(t *testing.T) {
+	dev := NewSimulatorDevice()
+	defer dev.Close()
+
+	_, err := selfTest(dev)
+	assert.NoError(t, err)
+	buffer, err := dev.GetMetrics()
+	assert.NoError(t, err)
+	var metric MetricEnvelop
+	assert.NoError(t, metric.Deserialize(deserializer{}, buffer))
+	signCount, verifyCount, errorCount := metric.Counter()
+	assert.Equal(t, 0, signCount+verifyCount+errorCount)
+
+	for _, c := range []Curve{CurveP224, CurveSecp256k1} {
+		_, err = selfTestCurve(dev, c)
+		assert.NoError(t, err, c.String())
+	}
+
+	// any result other than resultOK fails verification
+	_, err = selfTest(errorCodeDevice{dev})
+	assert.NoError(t, err)
+
+	_, err = selfTest(&wrongDevice{SimulatorDevice: dev})
+	assert.True(t, errors.Is(err, ErrSelfTestFailed))
+
+	_, err = NewManager(Options{Devices: []Device{NewSimulatorDevice(), &wrongDevice{SimulatorDevice: NewSimulatorDevice()}}, MaxPending: maxPending})
+	assert.True(t, errors.Is(err, ErrSelfTestFailed))
+	var devErr *DeviceError
+	assert.True(t, errors.As(err, &devErr))
+	assert.Equal(t, 1, devErr.Index)
+}
@@ -5,9 +5,9 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"log"
 	"os"
 	"strconv"
+	"time"
 )
 
 const (
@@ -20,6 +20,15 @@ const (
 	// MetricSetSize is buffer size of MetricSet
 	MetricSetSize = 28
 	rwUnitBytes   = 4
+
+	// SignRequestHeader is the first 8 bytes of sign request, the lower 32 bits carry curve
+	SignRequestHeader uint64 = 0xaaaaaaaa00000000
+	// VerifyRequestHeader is the first 8 bytes of verify request, the lower 32 bits carry curve
+	VerifyRequestHeader uint64 = 0xbbbbbbbb00000000
+	// SignResponseHeader is the first 4 bytes of sign response, as in the response frame pinned by
+	// TestDeserializeResponse. Header of verify response is not known, responses of other headers are
+	// taken as verify responses.
+	SignResponseHeader uint32 = 0x0000aaaa
 )
 
 // FPGADevice is a structue to store device file descriptors
@@ -36,25 +45,27 @@ func NewFPGADevice(index int) (*FPGADevice, error) {
 
 	h2c, err := os.OpenFile(prefix+"_h2c_0", os.O_WRONLY|os.O_EXCL, os.ModeDevice)
 	if err != nil {
-		log.Fatal(err)
 		return nil, err
 	}
 
 	c2h, err := os.OpenFile(prefix+"_c2h_0", os.O_RDONLY|os.O_EXCL, os.ModeDevice)
 	if err != nil {
-		log.Fatal(err)
+		h2c.Close()
 		return nil, err
 	}
 
 	ctrl, err := os.OpenFile(prefix+"_control", os.O_RDONLY|os.O_EXCL, os.ModeDevice)
 	if err != nil {
-		log.Fatal(err)
+		h2c.Close()
+		c2h.Close()
 		return nil, err
 	}
 
 	user, err := os.OpenFile(prefix+"_user", os.O_RDWR|os.O_EXCL, os.ModeDevice)
 	if err != nil {
-		log.Fatal(err)
+		h2c.Close()
+		c2h.Close()
+		ctrl.Close()
 		return nil, err
 	}
 
@@ -67,29 +78,25 @@ func NewFPGADevice(index int) (*FPGADevice, error) {
 
 	err = dev.Reset()
 	if err != nil {
+		dev.Close()
 		return nil, err
 	}
 
 	return &dev, nil
 }
 
-// Close closes device descriptors
+// CancelPoll makes Poll blocked on d return with error, and Poll fails until Reset.
+// It returns os.ErrNoDeadline if c2h does not support deadlines.
+func (d *FPGADevice) CancelPoll() error {
+	return d.c2h.SetReadDeadline(time.Now())
+}
+
+// Close closes device descriptors, it returns the first error and closes the rest anyway
 func (d *FPGADevice) Close() (err error) {
-	err = d.h2c.Close()
-	if err != nil {
-		log.Fatal(err)
-	}
-	err = d.c2h.Close()
-	if err != nil {
-		log.Fatal(err)
-	}
-	err = d.ctrl.Close()
-	if err != nil {
-		log.Fatal(err)
-	}
-	err = d.user.Close()
-	if err != nil {
-		log.Fatal(err)
+	for _, f := range []*os.File{d.h2c, d.c2h, d.ctrl, d.user} {
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
 	}
 
 	return
@@ -173,6 +180,9 @@ func (d *FPGADevice) GetMetrics() ([]byte, error) {
 
 // Reset resets device
 func (d *FPGADevice) Reset() error {
+	// undo CancelPoll, c2h without deadline support is left as it is
+	d.c2h.SetReadDeadline(time.Time{})
+
 	stop := false
 	for !stop {
 		_, err := d.Poll()
@@ -7,12 +7,21 @@ import (
 	"crypto/sha256"
 	"encoding/binary"
 	"math/big"
+	"os"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
+// skipWithoutDevice skips test on hosts where MBPU is not installed
+func skipWithoutDevice(t *testing.T) {
+	if _, err := os.Stat("/dev/mdlx0_h2c_0"); err != nil {
+		t.Skip("MBPU is not installed")
+	}
+}
+
 func TestFPGA_OpenNClose(t *testing.T) {
+	skipWithoutDevice(t)
 	fpga, err := NewFPGADevice(0)
 	assert.NoError(t, err)
 	assert.NotNil(t, fpga)
@@ -22,6 +31,7 @@ func TestFPGA_OpenNClose(t *testing.T) {
 }
 
 func TestFPGA_Sign_CPU_Verify(t *testing.T) {
+	skipWithoutDevice(t)
 	// generate private key
 	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	assert.NoError(t, err)
@@ -67,6 +77,7 @@ func TestFPGA_Sign_CPU_Verify(t *testing.T) {
 }
 
 func TestCPU_Sign_FPGA_Verify(t *testing.T) {
+	skipWithoutDevice(t)
 	// newfpgadevice
 	dev, err := NewFPGADevice(0)
 	assert.NoError(t, err)
@@ -93,6 +104,7 @@ func TestCPU_Sign_FPGA_Verify(t *testing.T) {
 }
 
 func TestFPGADevice_CheckAvailable(t *testing.T) {
+	skipWithoutDevice(t)
 	dev, err := NewFPGADevice(0)
 	assert.NoError(t, err)
 	assert.NotNil(t, dev)
@@ -105,6 +117,7 @@ func TestFPGADevice_CheckAvailable(t *testing.T) {
 }
 
 func TestFPGADevice_GetMetrics(t *testing.T) {
+	skipWithoutDevice(t)
 	dev, err := NewFPGADevice(0)
 	assert.NoError(t, err)
 	assert.NotNil(t, dev)
@@ -118,6 +131,7 @@ func TestFPGADevice_GetMetrics(t *testing.T) {
 }
 
 func TestFPGADevice_Reset(t *testing.T) {
+	skipWithoutDevice(t)
 	dev, err := NewFPGADevice(0)
 	assert.NoError(t, err)
 	assert.NotNil(t, dev)
@@ -142,6 +156,7 @@ func TestFPGADevice_Reset(t *testing.T) {
 }
 
 func TestFPGADevice_Version(t *testing.T) {
+	skipWithoutDevice(t)
 	dev, err := NewFPGADevice(0)
 	assert.NoError(t, err)
 	assert.NotNil(t, dev)
@@ -231,3 +246,8 @@ func genVerifySet(userctx int) ([]byte, error) {
 
 	return reqBuf, nil
 }
+
+func TestNewFPGADevice_NotFound(t *testing.T) {
+	_, err := NewFPGADevice(-1)
+	assert.Error(t, err)
+}
@@ -0,0 +1,164 @@
+/*
+Copyright Medium Corp. 2020 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package mediumpk
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+)
+
+// Logger is a leveled logger taking a message and alternating keys and values,
+// like slog.Logger or zap.SugaredLogger with *w methods wrapped.
+type Logger interface {
+	Debug(msg string, keysAndValues ...interface{})
+	Info(msg string, keysAndValues ...interface{})
+	Warn(msg string, keysAndValues ...interface{})
+	Error(msg string, keysAndValues ...interface{})
+}
+
+// Level is the severity of log message
+type Level int
+
+const (
+	// LevelDebug is for messages useful only while debugging
+	LevelDebug Level = iota
+	// LevelInfo is for state changes of Manager and MBPU
+	LevelInfo
+	// LevelWarn is for recoverable failures
+	LevelWarn
+	// LevelError is for failures such as MBPU down
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBU"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERRO"
+	}
+	return fmt.Sprintf("LEVEL(%d)", int(l))
+}
+
+// stdLogger writes messages at or above level through log.Logger
+type stdLogger struct {
+	logger *log.Logger
+	level  Level
+}
+
+// NewLogger returns Logger writing messages at or above level to out as
+// "[MBPU][INFO] : msg key=value ..."
+func NewLogger(out io.Writer, level Level) Logger {
+	return &stdLogger{log.New(out, "", log.LstdFlags|log.LUTC), level}
+}
+
+func (l *stdLogger) Debug(msg string, keysAndValues ...interface{}) {
+	l.output(LevelDebug, msg, keysAndValues)
+}
+
+func (l *stdLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.output(LevelInfo, msg, keysAndValues)
+}
+
+func (l *stdLogger) Warn(msg string, keysAndValues ...interface{}) {
+	l.output(LevelWarn, msg, keysAndValues)
+}
+
+func (l *stdLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.output(LevelError, msg, keysAndValues)
+}
+
+func (l *stdLogger) output(level Level, msg string, keysAndValues []interface{}) {
+	if level < l.level {
+		return
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "[MBPU][%s] : %s", level, msg)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		if i+1 < len(keysAndValues) {
+			fmt.Fprintf(&b, " %v=%v", keysAndValues[i], keysAndValues[i+1])
+		} else {
+			fmt.Fprintf(&b, " %v", keysAndValues[i])
+		}
+	}
+	l.logger.Output(3, b.String())
+}
+
+// nopLogger discards every message
+type nopLogger struct{}
+
+// NopLogger returns Logger discarding every message
+func NopLogger() Logger {
+	return nopLogger{}
+}
+
+func (nopLogger) Debug(msg string, keysAndValues ...interface{}) {}
+func (nopLogger) Info(msg string, keysAndValues ...interface{})  {}
+func (nopLogger) Warn(msg string, keysAndValues ...interface{})  {}
+func (nopLogger) Error(msg string, keysAndValues ...interface{}) {}
+
+// fieldLogger prepends fields such as device index to every message
+type fieldLogger struct {
+	logger Logger
+	fields []interface{}
+}
+
+// withFields returns Logger adding keysAndValues to every message of l
+func withFields(l Logger, keysAndValues ...interface{}) Logger {
+	return &fieldLogger{l, keysAndValues}
+}
+
+func (l *fieldLogger) Debug(msg string, keysAndValues ...interface{}) {
+	l.logger.Debug(msg, l.merge(keysAndValues)...)
+}
+
+func (l *fieldLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.logger.Info(msg, l.merge(keysAndValues)...)
+}
+
+func (l *fieldLogger) Warn(msg string, keysAndValues ...interface{}) {
+	l.logger.Warn(msg, l.merge(keysAndValues)...)
+}
+
+func (l *fieldLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.logger.Error(msg, l.merge(keysAndValues)...)
+}
+
+func (l *fieldLogger) merge(keysAndValues []interface{}) []interface{} {
+	merged := make([]interface{}, 0, len(l.fields)+len(keysAndValues))
+	merged = append(merged, l.fields...)
+	return append(merged, keysAndValues...)
+}
+
+var (
+	defaultLogger     Logger = NewLogger(log.Writer(), LevelInfo)
+	defaultLoggerLock sync.RWMutex
+)
+
+// SetDefaultLogger sets Logger of managers created without Options.Logger afterwards
+func SetDefaultLogger(l Logger) {
+	if l == nil {
+		l = NopLogger()
+	}
+	defaultLoggerLock.Lock()
+	defer defaultLoggerLock.Unlock()
+	defaultLogger = l
+}
+
+func getDefaultLogger() Logger {
+	defaultLoggerLock.RLock()
+	defer defaultLoggerLock.RUnlock()
+	return defaultLogger
+}
@@ -0,0 +1,32 @@
+package mediumpk
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := withFields(NewLogger(&buf, LevelInfo), "device", 1)
+
+	logger.Debug("hidden")
+	logger.Info("shown", "pending", 3)
+	logger.Error("odd", "error")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, 2, len(lines))
+	assert.True(t, strings.HasSuffix(lines[0], "[MBPU][INFO] : shown device=1 pending=3"))
+	assert.True(t, strings.HasSuffix(lines[1], "[MBPU][ERRO] : odd device=1 error"))
+}
+
+func TestNewManager_Logger(t *testing.T) {
+	var buf bytes.Buffer
+	m := newTestManager(t, Options{Devices: []Device{NewSimulatorDevice()}, Logger: NewLogger(&buf, LevelDebug)})
+	assert.NoError(t, m.Close())
+
+	assert.Contains(t, buf.String(), "MBPUManager initialized mbpuCount=1 maxPending=64")
+	assert.Contains(t, buf.String(), "MBPUManager closed")
+}
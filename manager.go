@@ -7,170 +7,478 @@ SPDX-License-Identifier: Apache-2.0
 package mediumpk
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"runtime"
 	"sync"
 	"sync/atomic"
+	"time"
+
+	"github.com/the-medium/mediumpk/internal"
 )
 
 var (
-	fm   *mbpuManager = nil
-	lock              = &sync.Mutex{}
-	// loggerInfo               = log.New(&buf, "[MBPU][INFO] : ", log.Lshortfile|log.Ldate|log.Ltime|log.LUTC)
-	// loggerError              = log.New(&buf, "[MBPU][ERRO] : ", log.Lshortfile)
-	emergencyMsg string = `======================================
-	====[EMERGENCY] MBPU DOWN DETECTED====
-	=======SWITCH TO CPU OPERATION========
-	======================================
-	`
+	fm   *Manager = nil
+	lock          = &sync.RWMutex{}
 )
 
 type requestWrapper struct {
-	env      RequestEnvelop
-	respChan chan ResponseEnvelop
+	ctx        context.Context
+	env        RequestEnvelop
+	resp       ResponseEnvelop
+	done       chan struct{}
+	once       sync.Once
+	accepted   chan struct{} // closed when MBPU or cpu-goroutine takes req, or when it is answered
+	acceptOnce sync.Once
+	enqueued   time.Time
+	pushed     time.Time
+	retries    int // times rerouted, owned by whoever holds req
+}
+
+func newRequestWrapper(ctx context.Context, env RequestEnvelop) *requestWrapper {
+	return &requestWrapper{
+		ctx:      ctx,
+		env:      env,
+		done:     make(chan struct{}),
+		accepted: make(chan struct{}),
+		enqueued: time.Now(),
+	}
+}
+
+// accept tells Submit that req is taken into a slot of MBPU or by cpu-goroutine
+func (req *requestWrapper) accept() {
+	req.acceptOnce.Do(func() {
+		close(req.accepted)
+	})
 }
 
-type mbpuManager struct {
-	chanRequest chan requestWrapper
-	wg          *sync.WaitGroup
+// notify stores response and wakes up requester, only the first response is taken
+func (req *requestWrapper) notify(resp ResponseEnvelop) {
+	req.once.Do(func() {
+		req.resp = resp
+		close(req.done)
+	})
+	req.accept()
 }
 
-// InitMBPUManager opens MBPU device and runs goroutine each for request/response to/from MBPU
-func InitMBPUManager(mbpuCount int, maxPending int, metricSocketPath string) (err error) {
-	if fm != nil {
-		return fmt.Errorf("mbpu manager is already initialized")
+// Options is the configuration of Manager
+type Options struct {
+	// Devices are MBPUs driven by Manager, Manager takes ownership and closes them.
+	// If it is empty, DeviceCount MBPUs are opened from /dev/mdlxN_*.
+	Devices []Device
+	// DeviceCount is the number of MBPUs to open when Devices is empty
+	DeviceCount int
+	// MaxPending is the number of requests each MBPU holds at once
+	MaxPending int
+	// MetricSocketPath is the directory of metric unix sockets, /var/run/ is used if empty
+	MetricSocketPath string
+	// CPUFallback computes requests on CPU when MBPU is down instead of failing with ErrDeviceDown
+	CPUFallback bool
+	// ConsistencyCheck verifies signatures generated by MBPU before they are returned.
+	// MBPU generating signature which does not verify is declared down.
+	ConsistencyCheck ConsistencyCheck
+	// ConsistencySampleRate is the fraction of signatures verified by ConsistencyCheck, in (0, 1].
+	// Every signature is verified if zero.
+	ConsistencySampleRate float64
+	// ConsistencyWorkers is the number of signatures ConsistencyCheck verifies at once apart from polling,
+	// the number of CPUs if zero. When they are all busy, polling-goroutine verifies signature on CPU
+	// before it polls the next response. Negative value makes polling-goroutine verify every signature.
+	ConsistencyWorkers int
+	// Weights are relative capacities of MBPUs, requests are routed to MBPU with the least pending
+	// requests per weight. Every MBPU weighs 1 if empty.
+	Weights []float64
+	// ThrottleTemperature is the temperature in celsius above which MBPU is not given requests
+	// while other MBPUs are cooler, until it cools down 5 degrees below. Zero disables throttling.
+	ThrottleTemperature float64
+	// QueueDepth is the number of requests of each priority waiting for dispatch. Request blocks and
+	// TryRequest fails with ErrQueueFull when the queue is full. MaxPending times the number of MBPUs
+	// is used if zero, negative value makes requests wait without queue. Requests of curve no MBPU computes
+	// wait in a queue of their own of the same depth.
+	QueueDepth int
+	// StarvationLimit is the number of requests of higher priority dispatched in a row before
+	// a waiting request of lower priority goes. 100 is used if zero, negative value disables it.
+	StarvationLimit int
+	// MaxRetries is the number of times request MBPU went down without answering is retried on another MBPU,
+	// after which it is served as if every MBPU was down. 2 is used if zero, negative value disables retry.
+	MaxRetries int
+	// RequestTimeout is how long request may be pending on MBPU. MBPU holding a request longer is
+	// declared stuck and down, as if it failed. 10 seconds is used if zero, negative value disables it.
+	// MBPU stuck is re-admitted once Poll blocked on it returns, which is cancelled if it is PollCanceler.
+	RequestTimeout time.Duration
+	// HealthCheckInterval is the interval of health check of MBPU down, which re-admits MBPU once it passes.
+	// 10 seconds is used if zero, negative value disables recovery.
+	HealthCheckInterval time.Duration
+	// DeviceCurves are curves other than P-256 computed by MBPUs, requests of other curves are computed on CPU.
+	// Curve is put into the lower 32 bits of request header, which only bitstreams documented to take it do,
+	// so every curve other than P-256 is computed on CPU if empty. P-384 and P-521 do not fit in frame.
+	// Each MBPU runs known-answer tests of the curves when opened, and the curves it fails are computed on CPU.
+	DeviceCurves []Curve
+	// Logger receives logs of Manager and its MBPUs, the logger set by SetDefaultLogger is used if nil
+	Logger Logger
+}
+
+// Manager runs goroutines each for request/response to/from MBPUs and dispatches requests to them.
+// Each Manager has its own devices, goroutines and metrics, so several managers can run in one process.
+type Manager struct {
+	mpks          []*Mediumpk
+	chanRequest   [numPriorities]chan *requestWrapper // indexed by Priority
+	wg            sync.WaitGroup
+	closing       chan struct{} // closed when shutdown starts
+	stopDispatch  chan struct{} // closed when no more request is queued
+	abort         chan struct{} // closed when shutdown gives up outstanding responses
+	done          chan struct{} // closed when shutdown is over
+	admission     sync.RWMutex
+	closeOnce     sync.Once
+	abortOnce     sync.Once
+	closed        int32
+	abandoned     int32
+	cpuFallback   int32
+	fallbackCount uint64
+	logger        Logger
+
+	healthCheckInterval   time.Duration
+	consistencyCheck      ConsistencyCheck
+	consistencySampleRate float64
+	consistencySlots      chan struct{} // taken by each check apart from polling
+	verifierIndex         uint32
+	throttleTemperature   float64
+	dispatchIndex         uint32
+	starvationLimit       int
+	maxRetries            int
+	curves                curveSet // curves any of MBPUs computes
+	requestTimeout        time.Duration
+	starved               [numPriorities]int // owned by dispatcher-goroutine
+	rejected              [numPriorities]uint64
+	cpuRequests           chan *requestWrapper // requests computed on CPU, taken by cpu-goroutines
+	cpuQueue              chan *requestWrapper // requests of curve no MBPU computes, queued apart from dispatcher
+}
+
+// NewManager opens MBPU devices and runs goroutine each for request/response to/from MBPU
+func NewManager(opts Options) (*Manager, error) {
+	if opts.MaxPending < 1 {
+		return nil, fmt.Errorf("maxPending must larger than or equal to 1")
 	}
 
-	if mbpuCount < 1 {
-		return fmt.Errorf("mbpuCount must larger than or equal to 1")
+	if opts.ConsistencySampleRate < 0 || opts.ConsistencySampleRate > 1 {
+		return nil, fmt.Errorf("consistencySampleRate must be in [0, 1]")
 	}
 
-	lock.Lock()
-	defer lock.Unlock()
+	deviceCount := len(opts.Devices)
+	if deviceCount == 0 {
+		deviceCount = opts.DeviceCount
+	}
+	if len(opts.Weights) != 0 && len(opts.Weights) != deviceCount {
+		return nil, fmt.Errorf("weights must be given for each of %d mbpus", deviceCount)
+	}
+	for _, w := range opts.Weights {
+		if !(w > 0) {
+			return nil, fmt.Errorf("weight must be larger than 0")
+		}
+	}
 
-	var wg sync.WaitGroup
-	fm = &mbpuManager{
-		make(chan requestWrapper),
-		&wg,
+	curves, err := deviceCurves(opts.DeviceCurves)
+	if err != nil {
+		return nil, err
+	}
+
+	logger := opts.Logger
+	if logger == nil {
+		logger = getDefaultLogger()
+	}
+
+	devices := opts.Devices
+	if len(devices) == 0 {
+		if opts.DeviceCount < 1 {
+			return nil, fmt.Errorf("mbpuCount must larger than or equal to 1")
+		}
+		for i := 0; i < opts.DeviceCount; i++ {
+			dev, err := internal.NewFPGADevice(i)
+			if err != nil {
+				closeDevices(logger, devices)
+				return nil, err
+			}
+			devices = append(devices, dev)
+		}
 	}
 
-	for i := 0; i < mbpuCount; i++ {
-		mpk, err := newMediumpk(i, maxPending, metricSocketPath)
+	m := &Manager{
+		closing:      make(chan struct{}),
+		cpuRequests:  make(chan *requestWrapper),
+		stopDispatch: make(chan struct{}),
+		abort:        make(chan struct{}),
+		done:         make(chan struct{}),
+		logger:       logger,
+
+		healthCheckInterval:   opts.HealthCheckInterval,
+		consistencyCheck:      opts.ConsistencyCheck,
+		consistencySampleRate: opts.ConsistencySampleRate,
+		throttleTemperature:   opts.ThrottleTemperature,
+		starvationLimit:       opts.StarvationLimit,
+		maxRetries:            opts.MaxRetries,
+		requestTimeout:        opts.RequestTimeout,
+	}
+	queueDepth := opts.QueueDepth
+	if queueDepth == 0 {
+		queueDepth = opts.MaxPending * len(devices)
+	} else if queueDepth < 0 {
+		queueDepth = 0
+	}
+	for p := range m.chanRequest {
+		m.chanRequest[p] = make(chan *requestWrapper, queueDepth)
+	}
+	m.cpuQueue = make(chan *requestWrapper, queueDepth)
+	if m.requestTimeout == 0 {
+		m.requestTimeout = defaultRequestTimeout
+	}
+	if m.maxRetries == 0 {
+		m.maxRetries = defaultMaxRetries
+	}
+	if m.starvationLimit == 0 {
+		m.starvationLimit = defaultStarvationLimit
+	}
+	if m.consistencySampleRate == 0 {
+		m.consistencySampleRate = 1
+	}
+	consistencyWorkers := opts.ConsistencyWorkers
+	if consistencyWorkers == 0 {
+		consistencyWorkers = runtime.NumCPU()
+	} else if consistencyWorkers < 0 {
+		consistencyWorkers = 0
+	}
+	m.consistencySlots = make(chan struct{}, consistencyWorkers)
+	if m.healthCheckInterval == 0 {
+		m.healthCheckInterval = defaultHealthCheckInterval
+	}
+	m.SetCPUFallback(opts.CPUFallback)
+
+	mpks := make([]*Mediumpk, len(devices))
+	for i := range devices {
+		mpk, err := newMediumpk(i, m, devices[i], opts.MaxPending, opts.MetricSocketPath, curves)
+		if err == nil {
+			err = mpk.startMetric()
+		}
 		if err != nil {
-			return err
+			for _, started := range mpks[:i] {
+				started.stopMetric()
+			}
+			closeDevices(logger, devices)
+			return nil, err
+		}
+		if len(opts.Weights) != 0 {
+			mpk.weight = opts.Weights[i]
 		}
-		var available int32 = int32(maxPending)
-		chPoll := make(chan bool, maxPending)
-		chPendable := make(chan bool)
+		mpks[i] = mpk
+	}
+	m.mpks = mpks
+	for _, mpk := range mpks {
+		m.curves |= mpk.curves
+	}
 
-		wg.Add(1)
-		chEmergency := runPushing(mpk, chPoll, chPendable, &available)
-		runPolling(mpk, chPoll, chPendable, chEmergency, &available)
+	for _, mpk := range mpks {
+		m.wg.Add(1)
+		runMediumpk(mpk)
+	}
+	m.wg.Add(1)
+	m.runDispatcher()
+	if m.throttleTemperature > 0 {
+		m.wg.Add(1)
+		m.runThrottle()
 	}
+	m.runCPUWorkers()
 
-	log.Println("MBPUManager Initialized...")
-	log.Printf("MBPUCount: %d  MAXPENDING : %d \n", mbpuCount, maxPending)
+	logger.Info("MBPUManager initialized", "mbpuCount", len(devices), "maxPending", opts.MaxPending)
 
-	return err
+	return m, nil
 }
 
-// CloseMBPUManager closes MBPU Device and stops goroutines for request/response to/from MBPU
-func CloseMBPUManager() error {
-	lock.Lock()
-	defer lock.Unlock()
+func closeDevices(logger Logger, devices []Device) {
+	for i, dev := range devices {
+		if err := dev.Close(); err != nil {
+			logger.Warn("failed to close device", "device", i, "error", err)
+		}
+	}
+}
 
-	close(fm.chanRequest)
-	log.Println("MBPUManager request channel closed")
+// Request send RequestEnvelop to push-goroutine and waits for response.
+// It returns result code of MBPU with signature r, s and error. Errors can be checked with errors.Is
+// against ErrDeviceDown, ErrManagerClosed, ErrVerifyFailed, ErrSignFailed and ErrMalformedResponse.
+func (m *Manager) Request(env RequestEnvelop) (int, []byte, []byte, error) {
+	return m.RequestContext(context.Background(), env)
+}
 
-	fm.wg.Wait()
-	fm = nil
-	log.Println("MBPUManager Closed")
-	return nil
+// RequestContext is like Request but gives up when ctx is done, either while queueing into push-goroutine
+// or while waiting for response. The slot of an abandoned request is freed when its late response arrives.
+func (m *Manager) RequestContext(ctx context.Context, env RequestEnvelop) (int, []byte, []byte, error) {
+	req, err := m.submit(ctx, env)
+	if err != nil {
+		return -1, []byte(nil), []byte(nil), err
+	}
+	return req.wait()
 }
 
-// Request send RequestEnvelop to push-goroutine with channel for receive response
-func Request(env RequestEnvelop) (int, []byte, []byte) {
-	respChan := make(chan ResponseEnvelop, 1)
-	req := requestWrapper{
-		env,
-		respChan,
+// submit validates env and hands it over to push-goroutine without waiting for response
+func (m *Manager) submit(ctx context.Context, env RequestEnvelop) (*requestWrapper, error) {
+	if m == nil {
+		return nil, ErrManagerClosed
 	}
+	m.admission.RLock()
+	defer m.admission.RUnlock()
+	if atomic.LoadInt32(&m.closed) == 1 {
+		return nil, ErrManagerClosed
+	}
+	// fields are stored canonical, CPU and consistency check take them as they are
+	env, err := env.canonical()
+	if err != nil {
+		return nil, err
+	}
+
+	req := newRequestWrapper(ctx, env)
+	select {
+	case m.queueOf(req) <- req:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-m.closing:
+		return nil, ErrManagerClosed
+	}
+	return req, nil
+}
 
-	fm.chanRequest <- req
-	respEnv, ok := <-respChan
-	if !ok {
-		return -1, []byte(nil), []byte(nil)
+// wait receives response of submitted request
+func (req *requestWrapper) wait() (int, []byte, []byte, error) {
+	select {
+	case <-req.done:
+	case <-req.ctx.Done():
+		return -1, []byte(nil), []byte(nil), req.ctx.Err()
+	}
+
+	respEnv := req.resp
+	if respEnv.err != nil {
+		return respEnv.Result(), []byte(nil), []byte(nil), respEnv.err
 	}
 
-	close(respChan)
 	r, s := respEnv.Signature()
-	return respEnv.Result(), r, s
+	if respEnv.Result() != 0 {
+		return respEnv.Result(), r, s, &ResultError{operationOf(req.env).String(), respEnv.Result()}
+	}
+	return respEnv.Result(), r, s, nil
 }
 
-func runPushing(mpk *Mediumpk, chPoll chan bool, chPendable chan bool, available *int32) chan bool {
-	stop := false
-
-	chEmergency := make(chan bool)
-	mpk.startMetric()
+// runMediumpk runs push/poll-goroutines of mpk. When MBPU goes down, it serves requests without MBPU
+// until health check passes and then runs push/poll-goroutines again.
+func runMediumpk(mpk *Mediumpk) {
+	mgr := mpk.manager
 	go func() {
-		for !stop {
-			select {
-			case <-chEmergency:
-				// mbpu is down
-				mpk.emergency = 1
-				mpk.clearChanStore()
-				go runEmergency()
-				stop = true
-				continue
-			case req, ok := <-fm.chanRequest:
-				if !ok {
-					// terminate this loop by CloseMBPUManager
-					stop = true
-					continue
-				}
-				if atomic.LoadInt32(available) == 0 {
-					chPendable <- true
-					<-chPendable
-				}
-				chPoll <- true
-
-				for {
-					idx, err := mpk.request(&req.respChan, req.env)
-					if err == nil { // good to go
-						atomic.AddInt32(available, -1)
-						break
-					}
-					// check error type
-					if idx == -1 { // maxPending refuse error... try again
-						log.Println(err.Error() + ", try again..")
-						continue
-					} else { // something has gone wrong
-						chEmergency <- true
-						fmt.Println(err)
-						break
-					}
-				}
+		for {
+			chPoll := make(chan bool, cap(mpk.slots))
+			chEmergency := make(chan error, 1)
+			mpk.chEmergency.Store(chEmergency)
+			pollDone := runPolling(mpk, chPoll, mpk.slots, chEmergency)
+			stopWatchdog := make(chan struct{})
+			mpk.runWatchdog(chEmergency, stopWatchdog)
+			down := runPushing(mpk, chPoll, mpk.slots, chEmergency)
+			close(stopWatchdog)
+			close(chPoll)
+			if !down {
+				mpk.waitResponses(pollDone)
+				break
 			}
+			if !mpk.waitRecovery(pollDone) {
+				break
+			}
+			atomic.StoreInt32(&mpk.emergency, 0)
 		}
-		close(chPoll)
-		close(chEmergency)
-		err := mpk.stopMetric()
-		if err != nil {
-			log.Println(err.Error())
+
+		if err := mpk.close(); err != nil {
+			mpk.logger.Warn("failed to close device", "error", err)
 		}
+		mgr.wg.Done()
+	}()
+}
 
-		err = mpk.close()
-		if err != nil {
-			log.Println(err.Error())
+// runPushing sends requests to MBPU until manager is closed or MBPU goes down, it returns true for the latter
+func runPushing(mpk *Mediumpk, chPoll chan bool, slots chan bool, chEmergency chan error) bool {
+	mgr := mpk.manager
+	for {
+		var req *requestWrapper
+		select {
+		case err := <-chEmergency:
+			// mbpu is down
+			mpk.logger.Error("MBPU down detected", "error", err, "pending", atomic.LoadInt32(&mpk.count))
+			atomic.StoreInt32(&mpk.emergency, 1)
+			if mgr.devicesUp() == 0 {
+				enabled, _ := mgr.cpuFallbackState()
+				mgr.logger.Error("every MBPU is down, requests are served without MBPU", "cpuFallback", enabled == 1)
+			}
+			// pending requests are served by the other MBPUs
+			mpk.clearChanStore(mpk.reroute)
+			return true
+		case <-mgr.closing:
+			// terminate this loop by CloseMBPUManager
+			return false
+		case req = <-mpk.chDirect:
 		}
 
-		fm.wg.Done()
-	}()
-	return chEmergency
+		if req.ctx.Err() != nil {
+			// requester gave up while queueing
+			req.notify(ResponseEnvelop{result: -1, err: req.ctx.Err()})
+			continue
+		}
+		stats := &mpk.stats[operationOf(req.env)]
+		if len(slots) == 0 {
+			atomic.AddUint64(&stats.slotFull, 1)
+		}
+		select {
+		case <-slots:
+		case err := <-chEmergency:
+			// mbpu went down while waiting for the slot, it must not be picked again
+			atomic.StoreInt32(&mpk.emergency, 1)
+			notifyEmergency(chEmergency, err)
+			mpk.reroute(req)
+			continue
+		case <-mgr.abort:
+			// shutdown gave up waiting for mbpu to free a slot
+			req.notify(ResponseEnvelop{result: -1, err: ErrManagerClosed})
+			atomic.StoreInt32(&mgr.abandoned, 1)
+			return false
+		}
+		if req.ctx.Err() != nil {
+			// requester gave up while waiting for the slot
+			req.notify(ResponseEnvelop{result: -1, err: req.ctx.Err()})
+			slots <- true
+			continue
+		}
+
+		for {
+			_, err := mpk.request(req)
+			if err == nil { // good to go
+				req.accept()
+				stats.queueWait.observe(req.pushed.Sub(req.enqueued))
+				chPoll <- true
+				break
+			}
+			// check error type
+			if errors.Is(err, ErrQueueFull) { // maxPending refuse error... try again
+				atomic.AddUint64(&stats.retries, 1)
+				mpk.logger.Debug("slot is taken, try again", "error", err)
+				continue
+			} else if errors.Is(err, ErrInvalidEnvelop) { // requester's fault, mbpu is fine
+				req.notify(ResponseEnvelop{result: -1, err: err})
+				slots <- true
+				break
+			} else { // something has gone wrong, request is answered by clearChanStore
+				notifyEmergency(chEmergency, err)
+				break
+			}
+		}
+	}
 }
 
-func runPolling(mpk *Mediumpk, chPoll <-chan bool, chPendable chan bool, chEmergency chan bool, available *int32) {
+// runPolling runs polling-goroutine receiving responses from MBPU, the returned channel is closed when it exits
+func runPolling(mpk *Mediumpk, chPoll <-chan bool, slots chan bool, chEmergency chan error) <-chan struct{} {
+	pollDone := make(chan struct{})
 	go func() {
+		defer close(pollDone)
 		stop := false
 
 		for !stop {
@@ -180,35 +488,42 @@ func runPolling(mpk *Mediumpk, chPoll <-chan bool, chPendable chan bool, chEmerg
 				continue
 			}
 			err := mpk.getResponseAndNotify()
+			if err != nil && atomic.LoadInt32(&mpk.manager.closed) == 1 {
+				// device is closed by shutdown
+				stop = true
+				continue
+			}
 			if err != nil {
-				log.Printf("emergency from polling %d\n ", *available)
-				chEmergency <- true
+				mpk.logger.Error("failed to poll response", "error", err, "availableSlots", len(slots))
+				notifyEmergency(chEmergency, err)
 				stop = true
-				log.Println(err.Error())
 				continue
 			}
 
-			select {
-			case <-chPendable:
-				atomic.AddInt32(available, 1)
-				chPendable <- true
-			default:
-				atomic.AddInt32(available, 1)
-			}
+			slots <- true
 		}
-		close(chPendable)
 	}()
+	return pollDone
 }
 
-func runEmergency() {
-	stop := false
-	fmt.Println(emergencyMsg)
-	for !stop {
-		req, ok := <-fm.chanRequest
-		if !ok { // terminate this loop by CloseMBPUManager
-			stop = true
-			continue
+// notifyEmergency reports error to push-goroutine without blocking
+func notifyEmergency(chEmergency chan error, err error) {
+	select {
+	case chEmergency <- err:
+	default:
+	}
+}
+
+// runEmergency reroutes requests routed to MBPU which is down until stop is closed or manager is closed
+func (m *Mediumpk) runEmergency(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-m.manager.closing:
+			return
+		case req := <-m.chDirect:
+			m.reroute(req)
 		}
-		close(req.respChan)
 	}
 }
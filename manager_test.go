@@ -2,21 +2,28 @@ package mediumpk
 
 import (
 	"bufio"
+	"context"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"math/big"
 	mrand "math/rand"
 	"os"
 	"runtime"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/stretchr/testify/assert"
 )
 
 var (
@@ -57,6 +64,168 @@ func TestMain(m *testing.M) {
 	os.Exit(0)
 }
 
+func TestRequestSign(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	h := sha256.Sum256([]byte(randString()))
+
+	d32 := make([]byte, 32)
+	k32 := make([]byte, 32)
+	d := priv.D.Bytes()
+	k, err := CreateRandomK(d, h[:])
+	assert.NoError(t, err)
+	copy(d32[32-len(d):], d)
+	copy(k32[32-len(k):], k)
+
+	result, r, s, err := Request(SignRequestEnvelop{D: d32, K: k32, H: h[:]})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result)
+	assert.True(t, VerifyCPU(&priv.PublicKey, h[:], new(big.Int).SetBytes(r), new(big.Int).SetBytes(s)))
+}
+
+func TestRequestVerify(t *testing.T) {
+	workload := data[0]
+	qx32 := make([]byte, 32)
+	qy32 := make([]byte, 32)
+	r32 := make([]byte, 32)
+	s32 := make([]byte, 32)
+	copy(qx32[32-len(workload.qx):], workload.qx)
+	copy(qy32[32-len(workload.qy):], workload.qy)
+	copy(r32[32-len(workload.r):], workload.r)
+	copy(s32[32-len(workload.s):], workload.s)
+
+	result, _, _, err := Request(VerifyRequestEnvelop{Qx: qx32, Qy: qy32, R: r32, S: s32, H: workload.h})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result)
+
+	r32[31] ^= 0xff
+	result, _, _, err = Request(VerifyRequestEnvelop{Qx: qx32, Qy: qy32, R: r32, S: s32, H: workload.h})
+	assert.True(t, errors.Is(err, ErrVerifyFailed))
+	assert.NotEqual(t, 0, result)
+}
+
+func TestRequestContext_Canceled(t *testing.T) {
+	d, _ := hex.DecodeString(strD)
+	k, _ := hex.DecodeString(strK)
+	h, _ := hex.DecodeString(strH1)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	result, r, s, err := RequestContext(ctx, SignRequestEnvelop{d, k, h, CurveP256})
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, -1, result)
+	assert.Nil(t, r)
+	assert.Nil(t, s)
+}
+
+func TestRequest_InvalidEnvelop(t *testing.T) {
+	result, _, _, err := Request(SignRequestEnvelop{})
+	assert.True(t, errors.Is(err, ErrInvalidEnvelop))
+	assert.Equal(t, -1, result)
+}
+
+func TestCPUFallback(t *testing.T) {
+	dev := &flakyDevice{SimulatorDevice: NewSimulatorDevice()}
+	m := newTestManager(t, Options{Devices: []Device{dev}})
+	atomic.StoreInt32(&dev.broken, 1)
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	h := sha256.Sum256([]byte(randString()))
+	k, err := CreateRandomK(priv.D.Bytes(), h[:])
+	assert.NoError(t, err)
+	env, err := NewSignRequestEnvelop(priv.D.Bytes(), k, h[:])
+	assert.NoError(t, err)
+
+	// device goes down without fallback
+	_, _, _, err = m.Request(env)
+	assert.True(t, errors.Is(err, ErrDeviceDown))
+
+	m.SetCPUFallback(true)
+	result, r, s, err := m.Request(env)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result)
+	assert.True(t, VerifyCPU(&priv.PublicKey, h[:], new(big.Int).SetBytes(r), new(big.Int).SetBytes(s)))
+
+	enabled, count := m.cpuFallbackState()
+	assert.Equal(t, 1, enabled)
+	assert.Equal(t, uint64(1), count)
+}
+
+func TestServeCPU_Bounded(t *testing.T) {
+	m := newTestManager(t, Options{Devices: []Device{NewSimulatorDevice()}, QueueDepth: 4})
+
+	priv, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	assert.NoError(t, err)
+	h := sha512.Sum384([]byte(randString()))
+	k, err := CreateRandomKCurve(CurveP384, priv.D.Bytes(), h[:])
+	assert.NoError(t, err)
+	env, err := NewCurveSignRequestEnvelop(CurveP384, priv.D.Bytes(), k, h[:])
+	assert.NoError(t, err)
+
+	// P-384 is computed by cpu-goroutines, submitting blocks instead of piling up goroutines
+	base := runtime.NumGoroutine()
+	futures := make([]*Future, 200)
+	for i := range futures {
+		futures[i] = m.Submit(context.Background(), env)
+		assert.True(t, runtime.NumGoroutine() <= base+2)
+	}
+	for _, f := range futures {
+		_, _, _, err := f.Wait()
+		assert.NoError(t, err)
+	}
+}
+
+func TestServeCPU_Apart(t *testing.T) {
+	m := newTestManager(t, Options{Devices: []Device{NewSimulatorDevice()}, MaxPending: 1, QueueDepth: 1})
+	mpk := m.mpks[0]
+
+	// push-goroutine and dispatcher hold a request each waiting for the slot, and the queue is full
+	takeSlots(mpk, 1)
+	futures := submitBlocked(m, 3, func() RequestEnvelop { return newTestSignRequest(t) })
+	assert.Eventually(t, func() bool { return len(m.chanRequest[PriorityNormal]) == 1 }, time.Second, time.Millisecond)
+
+	// P-384 does not wait behind them
+	priv, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	assert.NoError(t, err)
+	h := sha512.Sum384([]byte(randString()))
+	k, err := CreateRandomKCurve(CurveP384, priv.D.Bytes(), h[:])
+	assert.NoError(t, err)
+	env, err := NewCurveSignRequestEnvelop(CurveP384, priv.D.Bytes(), k, h[:])
+	assert.NoError(t, err)
+	result, _, _, err := m.TryRequest(env)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result)
+
+	mpk.slots <- true
+	waitFutures(t, futures, 3)
+}
+
+func TestNewManager_Independent(t *testing.T) {
+	m1 := newTestManager(t, Options{Devices: []Device{NewSimulatorDevice()}})
+	m2 := newTestManager(t, Options{Devices: []Device{NewSimulatorDevice(), NewSimulatorDevice()}})
+
+	workload := data[0]
+	env, err := NewVerifyRequestEnvelop(workload.qx, workload.qy, workload.r, workload.s, workload.h)
+	assert.NoError(t, err)
+
+	assert.NoError(t, m1.Close())
+	_, _, _, err = m1.Request(env)
+	assert.Equal(t, ErrManagerClosed, err)
+
+	result, _, _, err := m2.Request(env)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result)
+}
+
+func TestNewManager_InvalidOptions(t *testing.T) {
+	_, err := NewManager(Options{Devices: []Device{NewSimulatorDevice()}})
+	assert.Error(t, err)
+
+	_, err = NewManager(Options{MaxPending: maxPending})
+	assert.Error(t, err)
+}
+
 func BenchmarkSign(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		sign()
@@ -156,9 +325,59 @@ func setUp(fileName string, dataCount int) error {
 
 	maxProcs := runtime.GOMAXPROCS(0)
 	fmt.Println("GOMAXPROCS : ", strconv.Itoa(maxProcs))
-	err = InitMBPUManager(mbpuCount, maxPending, metricSocketPath)
+	return initSimulatorManager()
+}
 
-	return err
+func initSimulatorManager() error {
+	devices := make([]Device, mbpuCount)
+	for i := range devices {
+		devices[i] = NewSimulatorDevice()
+	}
+	return InitMBPUManagerWithDevices(devices, maxPending, metricSocketPath)
+}
+
+// newTestManager returns Manager with its own metric socket directory, it is closed at the end of test
+func newTestManager(t *testing.T, opts Options) *Manager {
+	dir, err := ioutil.TempDir("", "mbpu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.MaxPending == 0 {
+		opts.MaxPending = maxPending
+	}
+	opts.MetricSocketPath = dir
+
+	m, err := NewManager(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		m.Close()
+		os.RemoveAll(dir)
+	})
+	return m
+}
+
+// faultyDevice is a SimulatorDevice whose h2c channel is broken
+type faultyDevice struct {
+	*SimulatorDevice
+}
+
+func (d faultyDevice) Request(buffer []byte) error {
+	return errors.New("h2c write failed")
+}
+
+// flakyDevice is a SimulatorDevice whose h2c channel breaks and heals on demand
+type flakyDevice struct {
+	*SimulatorDevice
+	broken int32
+}
+
+func (d *flakyDevice) Request(buffer []byte) error {
+	if atomic.LoadInt32(&d.broken) == 1 {
+		return errors.New("h2c write failed")
+	}
+	return d.SimulatorDevice.Request(buffer)
 }
 
 func tearDown(fileName string) {
@@ -244,9 +463,9 @@ func sign() {
 		H: h32,
 	}
 
-	result, _, _ := Request(reqEnv)
-	if result != 0 {
-		if result == -1 {
+	result, _, _, err := Request(reqEnv)
+	if err != nil {
+		if errors.Is(err, ErrDeviceDown) {
 			x := new(big.Int)
 			x.SetBytes(workload.qx)
 			y := new(big.Int)
@@ -302,9 +521,9 @@ func verify() {
 		H:  h32,
 	}
 
-	result, _, _ := Request(reqEnv)
-	if result != 0 {
-		if result == -1 {
+	result, _, _, err := Request(reqEnv)
+	if err != nil {
+		if errors.Is(err, ErrDeviceDown) {
 			x := new(big.Int)
 			x.SetBytes(qx32)
 			y := new(big.Int)
@@ -1,32 +1,43 @@
 package mediumpk
 
 import (
-	"errors"
 	"fmt"
-	"log"
 	"net"
 	"os"
 	"strconv"
+	"sync"
 	"sync/atomic"
 	"time"
-
-	"github.com/the-medium/mediumpk/internal"
 )
 
 // Mediumpk is a structure to interact with FPGA
 type Mediumpk struct {
-	index      int
-	dev        *internal.FPGADevice
-	chanStore  []*chan ResponseEnvelop
-	chanEnd    chan bool
-	socketAddr string
-	count      int32
-	emergency  int
-	metricOn   bool
+	index       int
+	manager     *Manager
+	dev         Device
+	chanStore   []*requestWrapper
+	generations []uint32 // generation of request in each slot of chanStore
+	generation  uint32   // the last generation given, guarded by storeLock
+	storeLock   sync.Mutex
+	slots       chan bool
+	chDirect    chan *requestWrapper // requests only this MBPU is to serve
+	chEmergency atomic.Value         // chan error of current push-goroutine
+	chanEnd     chan struct{}
+	metricDone  chan struct{}
+	listener    net.Listener
+	socketAddr  string
+	count       int32
+	emergency   int32
+	logger      Logger
+	weight      float64
+	throttled   int32
+	curves      curveSet
+	stats       [2]opStats // indexed by operation
+	dropped     uint64
 }
 
 // New creates and returns Mediumpk instance
-func newMediumpk(index int, maxPending int, socketPath string) (*Mediumpk, error) {
+func newMediumpk(index int, manager *Manager, dev Device, maxPending int, socketPath string, curves curveSet) (*Mediumpk, error) {
 	if socketPath == "" {
 		socketPath = "/var/run/"
 	} else {
@@ -37,181 +48,320 @@ func newMediumpk(index int, maxPending int, socketPath string) (*Mediumpk, error
 	}
 	socketAddr := fmt.Sprintf("%s%s%s%s", socketPath, "/mbpu", strconv.Itoa(index), ".sock")
 
-	dev, err := internal.NewFPGADevice(index)
-	if err != nil {
-		return nil, err
+	// refuse device giving wrong answers, polling left behind by timeout ends when device is closed
+	if _, err := selfTest(dev); err != nil {
+		return nil, &DeviceError{index, err}
+	}
+	logger := withFields(manager.logger, "device", index)
+	// curves of Options.DeviceCurves device gets wrong are computed on CPU
+	for c := CurveP256; c < numCurves; c++ {
+		if c == CurveP256 || !curves.has(c) {
+			continue
+		}
+		pollDone, err := selfTestCurve(dev, c)
+		if err == nil {
+			continue
+		}
+		select {
+		case <-pollDone:
+		default:
+			// device does not answer, nor does it for the other curves
+			return nil, &DeviceError{index, err}
+		}
+		logger.Warn("curve is computed on CPU", "curve", c, "error", err)
+		curves &^= newCurveSet(c)
+		if err := dev.Reset(); err != nil {
+			return nil, &DeviceError{index, err}
+		}
+	}
+
+	slots := make(chan bool, maxPending)
+	for i := 0; i < maxPending; i++ {
+		slots <- true
 	}
 
-	return &Mediumpk{index, dev, make([]*chan ResponseEnvelop, maxPending), make(chan bool, 1), socketAddr, 0, 0, false}, nil
+	return &Mediumpk{
+		index:       index,
+		manager:     manager,
+		dev:         dev,
+		chanStore:   make([]*requestWrapper, maxPending),
+		generations: make([]uint32, maxPending),
+		slots:       slots,
+		chDirect:    make(chan *requestWrapper),
+		weight:      1,
+		curves:      curves,
+		socketAddr:  socketAddr,
+		logger:      logger,
+	}, nil
 }
 
 // Close releases Mediumpk instance
 func (m *Mediumpk) close() error {
-	m.stopMetric()
+	if err := m.stopMetric(); err != nil {
+		m.logger.Warn("failed to stop metric server", "error", err)
+	}
 	return m.dev.Close()
 }
 
 // Request send sign/verify request to FPGA
-func (m *Mediumpk) request(pchan *chan ResponseEnvelop, env RequestEnvelop) (int, error) {
-	idx, err := m.putChannel(pchan)
+func (m *Mediumpk) request(req *requestWrapper) (int, error) {
+	// set before putChannel so that polling-goroutine sees it
+	req.pushed = time.Now()
+	userctx, err := m.putChannel(req)
 	if err != nil {
-		return idx, err
+		return userctx, err
+	}
+
+	buffer, err := req.env.Bytes(serializer{}, userctx)
+	if err != nil {
+		// malformed envelop, free the slot and let requester know
+		m.getChannel(userctx, operationOf(req.env))
+		return userctx, err
 	}
 
 	atomic.AddInt32(&m.count, 1)
 
-	return idx, m.dev.Request(env.Bytes(serializer{}, idx))
+	return userctx, m.dev.Request(buffer)
 }
 
-// getResponseAndNotify get response from FPGA and send it to channel
+// getResponseAndNotify get response from FPGA and send it to channel.
+// Frames which are corrupted, stale or duplicated are dropped and the next one is polled.
 func (m *Mediumpk) getResponseAndNotify() (err error) {
-	buffer, err := m.dev.Poll()
-	if err != nil {
-		return
-	}
-
 	var resEnv ResponseEnvelop
-	idx, err := resEnv.Deserialize(deserializer{}, buffer)
-	if err != nil {
-		return
-	}
+	var req *requestWrapper
+	for {
+		buffer, err := m.dev.Poll()
+		if err != nil {
+			return err
+		}
 
-	ch, err := m.getChannel(idx)
-	if err != nil {
-		return
+		resEnv = ResponseEnvelop{}
+		userctx, err := resEnv.Deserialize(deserializer{}, buffer)
+		if err == nil {
+			req, err = m.getChannel(userctx, resEnv.op)
+		}
+		if err == nil {
+			break
+		}
+		if atomic.LoadInt32(&m.emergency) == 1 {
+			// pending requests are rerouted, nothing to wait for
+			return err
+		}
+		atomic.AddUint64(&m.dropped, 1)
+		m.logger.Warn("response dropped", "error", err)
 	}
 
 	atomic.AddInt32(&m.count, -1)
+	stats := &m.stats[operationOf(req.env)]
+	atomic.AddUint64(&stats.requests, 1)
+	stats.roundTrip.observe(time.Since(req.pushed))
 
-	*ch <- resEnv
+	if m.manager.needsConsistencyCheck(req, resEnv) {
+		select {
+		case m.manager.consistencySlots <- struct{}{}:
+			// verification must not hold up polling, shutdown waits for it
+			m.manager.wg.Add(1)
+			go func() {
+				defer m.manager.wg.Done()
+				valid := m.checkConsistency(req, resEnv, m.manager.consistencyCheck)
+				// free the slot before answering, so that the next request of requester finds it
+				<-m.manager.consistencySlots
+				m.answerChecked(req, resEnv, valid)
+			}()
+		default:
+			// checks are all busy, so verify here holding up polling. Verifying on another MBPU
+			// would wait for its polling-goroutine, which may be held up the same way.
+			m.answerChecked(req, resEnv, m.checkConsistency(req, resEnv, ConsistencyCheckCPU))
+		}
+		return
+	}
+
+	// late response of a request whose requester gave up is discarded by requester
+	req.notify(resEnv)
 
 	return
 }
 
-// must not be called concurrently
-func (m *Mediumpk) putChannel(resChan *chan ResponseEnvelop) (int, error) {
+// makeUserctx returns userctx of request in slot i of generation, the generation in the upper 32 bits
+// and the slot in the lower 32 bits. Generation tells response of request from the one of
+// a former request of the same slot.
+func makeUserctx(generation uint32, i int) int {
+	return int(uint64(generation)<<32 | uint64(uint32(i)))
+}
+
+// splitUserctx returns generation and slot of userctx
+func splitUserctx(userctx int) (uint32, int) {
+	return uint32(uint64(userctx) >> 32), int(uint32(userctx))
+}
+
+// putChannel stores req into a free slot and returns its userctx
+func (m *Mediumpk) putChannel(req *requestWrapper) (int, error) {
+	m.storeLock.Lock()
+	defer m.storeLock.Unlock()
+
 	for i, c := range m.chanStore {
 		if c == nil {
-			m.chanStore[i] = resChan
-			return i, nil
+			m.generation++
+			if m.generation == 0 {
+				// zero is left for frames zeroed out
+				m.generation++
+			}
+			m.chanStore[i] = req
+			m.generations[i] = m.generation
+			return makeUserctx(m.generation, i), nil
 		}
 	}
-	return -1, errors.New("no empty channel Store")
+	return -1, ErrQueueFull
 }
 
-// must not be called concurrently
-func (m *Mediumpk) getChannel(i int) (*chan ResponseEnvelop, error) {
+// getChannel takes request of userctx out of its slot, which must be pending for operation op
+func (m *Mediumpk) getChannel(userctx int, op operation) (*requestWrapper, error) {
+	m.storeLock.Lock()
+	defer m.storeLock.Unlock()
+
+	generation, i := splitUserctx(userctx)
 	if i >= len(m.chanStore) {
-		return nil, errors.New("out of range")
+		return nil, fmt.Errorf("%w: userctx 0x%x out of range", ErrMalformedResponse, userctx)
+	}
+	req := m.chanStore[i]
+	if req == nil || m.generations[i] != generation {
+		return nil, fmt.Errorf("%w: userctx 0x%x is not pending", ErrMalformedResponse, userctx)
 	}
-	if m.chanStore[i] == nil {
-		return nil, errors.New("nil chanStore")
+	if reqOp := operationOf(req.env); reqOp != op {
+		return nil, fmt.Errorf("%w: %s response to %s request", ErrMalformedResponse, op, reqOp)
 	}
-	resChan := m.chanStore[i]
 	m.chanStore[i] = nil
-	return resChan, nil
+	return req, nil
 }
 
-func (m *Mediumpk) clearChanStore() {
-	resEnv := ResponseEnvelop{
-		result: -1,
-		r:      []byte(nil),
-		s:      []byte(nil),
+// markDown declares MBPU down, requests are served without MBPU until it recovers
+func (m *Mediumpk) markDown(err error) {
+	if chEmergency, ok := m.chEmergency.Load().(chan error); ok {
+		notifyEmergency(chEmergency, err)
 	}
+}
+
+// clearChanStore answers every pending request with answer, which is called without storeLock as it may block
+func (m *Mediumpk) clearChanStore(answer func(req *requestWrapper)) {
+	var pending []*requestWrapper
+	m.storeLock.Lock()
 	for i := 0; i < len(m.chanStore); i++ {
 		if m.chanStore[i] != nil {
-			*m.chanStore[i] <- resEnv
+			pending = append(pending, m.chanStore[i])
+			m.chanStore[i] = nil
 		}
 	}
+	m.storeLock.Unlock()
+
+	for _, req := range pending {
+		answer(req)
+	}
 }
 
 // startMetric starts unix socket server to export metrics
-func (m *Mediumpk) startMetric() {
-	if m.metricOn == true {
-		log.Println("Metric is already started")
-		return
+func (m *Mediumpk) startMetric() error {
+	if m.listener != nil {
+		return nil
 	}
 
-	m.metricOn = true
-	go func() {
-		if err := os.RemoveAll(m.socketAddr); err != nil {
-			log.Fatal(err)
-		}
-
-		l, err := net.Listen("unix", m.socketAddr)
-		if err != nil {
-			log.Fatal("listen error:", err)
-		}
-		defer l.Close()
+	if err := os.RemoveAll(m.socketAddr); err != nil {
+		return err
+	}
+	l, err := net.Listen("unix", m.socketAddr)
+	if err != nil {
+		return err
+	}
 
+	m.listener = l
+	m.chanEnd = make(chan struct{})
+	m.metricDone = make(chan struct{})
+	go func() {
+		defer close(m.metricDone)
 		for {
-			select {
-			case <-m.chanEnd:
-				break
-			default:
-				// Accept new connections, dispatching them to echoServer
-				// in a goroutine.
-				conn, err := l.Accept()
-				if err != nil {
-					log.Fatal("accept error:", err)
+			// Accept new connections, dispatching them to echoServer
+			// in a goroutine.
+			conn, err := l.Accept()
+			if err != nil {
+				select {
+				case <-m.chanEnd:
+				default:
+					m.logger.Error("metric server stopped", "error", err)
 				}
-
-				go m.echoServer(conn)
+				return
 			}
+
+			go m.echoServer(conn)
 		}
 	}()
+	return nil
 }
 
-// StopMetric stops unix socket server
-func (m *Mediumpk) stopMetric() (err error) {
-	if m.metricOn == false {
+// stopMetric stops unix socket server
+func (m *Mediumpk) stopMetric() error {
+	if m.listener == nil {
 		return nil
 	}
 
-	m.chanEnd <- true
-	ticker := time.NewTicker(time.Duration(1) * time.Second)
-	leftCount := 10
-	for leftCount > 0 {
-		select {
-		case <-m.chanEnd:
-			leftCount = -1
-		case <-ticker.C:
-			fmt.Printf("[metric server] goroutine is not responding. check count left : %d\n", leftCount)
-			leftCount--
-		}
-	}
-
-	if leftCount == 0 {
-		err = fmt.Errorf("[metric server] goroutine is not stopped properly")
-	} else {
-		log.Println("[metric server] goroutine is properly stopped")
-	}
-
-	m.metricOn = false
+	close(m.chanEnd)
+	err := m.listener.Close()
+	<-m.metricDone
+	m.listener = nil
+	m.logger.Debug("metric server stopped")
 
-	return nil
+	return err
 }
 
-func (m *Mediumpk) echoServer(c net.Conn) {
+// getMetric reads metric information from device
+func (m *Mediumpk) getMetric() (MetricEnvelop, error) {
 	var resEnv MetricEnvelop
 	buffer, err := m.dev.GetMetrics()
 	if err != nil {
-		log.Println(err)
+		return resEnv, err
 	}
 
 	err = resEnv.Deserialize(deserializer{}, buffer)
+	return resEnv, err
+}
+
+func (m *Mediumpk) echoServer(c net.Conn) {
+	resEnv, err := m.getMetric()
 	if err != nil {
-		log.Println(err)
+		m.logger.Warn("failed to read metrics", "error", err)
 	}
 
 	vccint, vccaux, vccbram := resEnv.Voltages()
 	signCount, verifyCount, errorCount := resEnv.Counter()
-	msg := fmt.Sprintf(`{ "m_temperature":%s, "m_vccint":%s, "m_vccaux":%s, "m_vccbram":%s, "m_signCount":%d,"m_verifyCount":%d,"m_errorCount":%d, "m_emergency":%d }`, resEnv.Temperature(), vccint, vccaux, vccbram, signCount, verifyCount, errorCount, m.emergency)
+	fallback, fallbackCount := m.manager.cpuFallbackState()
+	stats := m.getStats()
+	var queueLength int
+	var queueRejected uint64
+	for _, q := range m.manager.QueueStats() {
+		queueLength += q.Length
+		queueRejected += q.Rejected
+	}
+	msg := fmt.Sprintf(`{ "m_temperature":%s, "m_vccint":%s, "m_vccaux":%s, "m_vccbram":%s, "m_signCount":%d,"m_verifyCount":%d,"m_errorCount":%d, "m_emergency":%d, "m_cpuFallback":%d, "m_cpuFallbackCount":%d, `+
+		`"h_signRequests":%d, "h_signQueueWaitUs":%d, "h_signRoundTripUs":%d, "h_signRetries":%d, "h_signSlotFull":%d, "h_signRerouted":%d, "h_signChecks":%d, "h_signMismatches":%d, `+
+		`"h_verifyRequests":%d, "h_verifyQueueWaitUs":%d, "h_verifyRoundTripUs":%d, "h_verifyRetries":%d, "h_verifySlotFull":%d, "h_verifyRerouted":%d, "h_verifyMismatches":%d, `+
+		`"h_dropped":%d, "q_length":%d, "q_rejected":%d }`,
+		resEnv.Temperature(), vccint, vccaux, vccbram, signCount, verifyCount, errorCount, atomic.LoadInt32(&m.emergency), fallback, fallbackCount,
+		stats.Sign.Requests, stats.Sign.QueueWait.Mean().Microseconds(), stats.Sign.RoundTrip.Mean().Microseconds(), stats.Sign.Retries, stats.Sign.SlotFull, stats.Sign.Rerouted, stats.Sign.Checks, stats.Sign.Mismatches,
+		stats.Verify.Requests, stats.Verify.QueueWait.Mean().Microseconds(), stats.Verify.RoundTrip.Mean().Microseconds(), stats.Verify.Retries, stats.Verify.SlotFull, stats.Verify.Rerouted, stats.Verify.Mismatches,
+		stats.Dropped, queueLength, queueRejected)
 	msgBytes := []byte(msg)
 	c.Write(msgBytes)
 	c.Close()
 }
 
+// getStats returns snapshot of host side statistics
+func (m *Mediumpk) getStats() DeviceStats {
+	return DeviceStats{
+		Index:   m.index,
+		Sign:    m.stats[opSign].snapshot(),
+		Verify:  m.stats[opVerify].snapshot(),
+		Dropped: atomic.LoadUint64(&m.dropped),
+	}
+}
+
 // GetVersion return mbpu version imformation
 func (m *Mediumpk) getVersion() (string, error) {
 	return m.dev.Version()
@@ -0,0 +1,93 @@
+package mediumpk
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// dupDevice is a SimulatorDevice giving every response twice once it is duplicating
+type dupDevice struct {
+	*SimulatorDevice
+	duplicating int32
+	last        []byte
+}
+
+func (d *dupDevice) Poll() ([]byte, error) {
+	if d.last != nil {
+		buffer := d.last
+		d.last = nil
+		return buffer, nil
+	}
+	buffer, err := d.SimulatorDevice.Poll()
+	if err == nil && atomic.LoadInt32(&d.duplicating) == 1 {
+		d.last = append([]byte(nil), buffer...)
+	}
+	return buffer, err
+}
+
+func TestUserctx(t *testing.T) {
+	generation, i := splitUserctx(makeUserctx(0xfffffffe, 63))
+	assert.Equal(t, uint32(0xfffffffe), generation)
+	assert.Equal(t, 63, i)
+}
+
+func TestGetChannel(t *testing.T) {
+	m := newTestManager(t, Options{Devices: []Device{NewSimulatorDevice()}})
+	mpk := m.mpks[0]
+	req := newRequestWrapper(context.Background(), newTestSignRequest(t))
+
+	mpk.storeLock.Lock()
+	mpk.generation = ^uint32(0)
+	mpk.storeLock.Unlock()
+	userctx, err := mpk.putChannel(req)
+	assert.NoError(t, err)
+	generation, i := splitUserctx(userctx)
+	assert.Equal(t, uint32(1), generation)
+
+	// response of another operation
+	_, err = mpk.getChannel(userctx, opVerify)
+	assert.True(t, errors.Is(err, ErrMalformedResponse))
+	// stale response of the same slot
+	_, err = mpk.getChannel(makeUserctx(generation-1, i), opSign)
+	assert.True(t, errors.Is(err, ErrMalformedResponse))
+	// out of range
+	_, err = mpk.getChannel(makeUserctx(generation, len(mpk.chanStore)), opSign)
+	assert.True(t, errors.Is(err, ErrMalformedResponse))
+
+	got, err := mpk.getChannel(userctx, opSign)
+	assert.NoError(t, err)
+	assert.Equal(t, req, got)
+	// duplicated response
+	_, err = mpk.getChannel(userctx, opSign)
+	assert.True(t, errors.Is(err, ErrMalformedResponse))
+
+	// sign response to verify request
+	workload := data[0]
+	verifyEnv, err := NewVerifyRequestEnvelop(workload.qx, workload.qy, workload.r, workload.s, workload.h)
+	assert.NoError(t, err)
+	userctx, err = mpk.putChannel(newRequestWrapper(context.Background(), verifyEnv))
+	assert.NoError(t, err)
+	_, err = mpk.getChannel(userctx, opSign)
+	assert.True(t, errors.Is(err, ErrMalformedResponse))
+	_, err = mpk.getChannel(userctx, opVerify)
+	assert.NoError(t, err)
+}
+
+func TestDuplicatedResponse(t *testing.T) {
+	dev := &dupDevice{SimulatorDevice: NewSimulatorDevice()}
+	m := newTestManager(t, Options{Devices: []Device{dev}})
+	atomic.StoreInt32(&dev.duplicating, 1)
+
+	for i := 0; i < 10; i++ {
+		result, _, _, err := m.Request(newTestSignRequest(t))
+		assert.NoError(t, err)
+		assert.Equal(t, 0, result)
+	}
+	// the last one is not polled yet
+	assert.Equal(t, uint64(9), m.Stats()[0].Dropped)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&m.mpks[0].emergency))
+}
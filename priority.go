@@ -0,0 +1,119 @@
+/*
+Copyright Medium Corp. 2020 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package mediumpk
+
+import (
+	"context"
+	"fmt"
+)
+
+// Priority is the priority class of request, requests of higher priority are dispatched to MBPUs first
+type Priority int
+
+const (
+	// PriorityLow is for bulk work which can wait
+	PriorityLow Priority = iota
+	// PriorityNormal is the priority of requests without priority
+	PriorityNormal
+	// PriorityHigh is for latency critical work
+	PriorityHigh
+
+	numPriorities = 3
+)
+
+// defaultStarvationLimit is the default of Options.StarvationLimit
+const defaultStarvationLimit = 100
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "low"
+	case PriorityNormal:
+		return "normal"
+	case PriorityHigh:
+		return "high"
+	}
+	return fmt.Sprintf("Priority(%d)", int(p))
+}
+
+type priorityKey struct{}
+
+// WithPriority returns a copy of ctx carrying priority p. Requests submitted with the returned context,
+// including those of Signer, Submit and batches, are dispatched by p.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// priorityOf returns priority carried by ctx, PriorityNormal if none, clamped into known priorities
+func priorityOf(ctx context.Context) Priority {
+	p, ok := ctx.Value(priorityKey{}).(Priority)
+	if !ok {
+		return PriorityNormal
+	}
+	if p < PriorityLow {
+		return PriorityLow
+	}
+	if p > PriorityHigh {
+		return PriorityHigh
+	}
+	return p
+}
+
+// nextRequest receives the next request to dispatch, the one of the highest priority waiting.
+// Once StarvationLimit requests of higher priority are dispatched in a row, a waiting request of
+// lower priority goes first. It returns false when manager stops dispatching.
+func (mgr *Manager) nextRequest() (*requestWrapper, bool) {
+	for {
+		// starving requests first, the lowest first
+		if mgr.starvationLimit > 0 {
+			for p := PriorityLow; p < PriorityHigh; p++ {
+				if mgr.starved[p] < mgr.starvationLimit {
+					continue
+				}
+				mgr.starved[p] = 0
+				select {
+				case req, ok := <-mgr.chanRequest[p]:
+					return mgr.took(p, req, ok)
+				default:
+				}
+			}
+		}
+
+		for p := PriorityHigh; p >= PriorityLow; p-- {
+			select {
+			case req, ok := <-mgr.chanRequest[p]:
+				return mgr.took(p, req, ok)
+			default:
+			}
+		}
+
+		// nothing is waiting, so nothing is starving
+		mgr.starved = [numPriorities]int{}
+		select {
+		case req, ok := <-mgr.chanRequest[PriorityHigh]:
+			return req, ok
+		case req, ok := <-mgr.chanRequest[PriorityNormal]:
+			return req, ok
+		case req, ok := <-mgr.chanRequest[PriorityLow]:
+			return req, ok
+		case <-mgr.stopDispatch:
+			return nil, false
+		}
+	}
+}
+
+// took counts request of priority p taken by nextRequest as starving the lower priorities
+func (mgr *Manager) took(p Priority, req *requestWrapper, ok bool) (*requestWrapper, bool) {
+	if !ok {
+		return nil, false
+	}
+	mgr.starved[p] = 0
+	for lower := PriorityLow; lower < p; lower++ {
+		mgr.starved[lower]++
+	}
+	return req, true
+}
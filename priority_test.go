@@ -0,0 +1,89 @@
+package mediumpk
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingDevice is a SimulatorDevice recording request frames in the order they arrive
+type recordingDevice struct {
+	*SimulatorDevice
+	mutex  sync.Mutex
+	frames [][]byte
+}
+
+func (d *recordingDevice) Request(buffer []byte) error {
+	d.mutex.Lock()
+	d.frames = append(d.frames, append([]byte(nil), buffer...))
+	d.mutex.Unlock()
+	return d.SimulatorDevice.Request(buffer)
+}
+
+// dispatchOrder submits requests of priorities while MBPU is stuck and returns priorities in the order
+// they reach MBPU
+func dispatchOrder(t *testing.T, opts Options, priorities []Priority) []Priority {
+	dev := &recordingDevice{SimulatorDevice: NewSimulatorDevice()}
+	opts.Devices = []Device{dev}
+	opts.MaxPending = 1
+	m := newTestManager(t, opts)
+
+	// push-goroutine holds the first request waiting for the slot, dispatcher holds the second
+	takeSlots(m.mpks[0], 1)
+	var wg sync.WaitGroup
+	submit := func(p Priority) SignRequestEnvelop {
+		env := newTestSignRequest(t)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, _, err := m.RequestContext(WithPriority(context.Background(), p), env)
+			assert.NoError(t, err)
+		}()
+		time.Sleep(10 * time.Millisecond)
+		return env
+	}
+	submit(PriorityNormal)
+	submit(PriorityNormal)
+
+	envs := make([]SignRequestEnvelop, len(priorities))
+	for i, p := range priorities {
+		envs[i] = submit(p)
+	}
+	m.mpks[0].slots <- true
+	wg.Wait()
+
+	dev.mutex.Lock()
+	defer dev.mutex.Unlock()
+	var order []Priority
+	for _, frame := range dev.frames[2:] {
+		for i, env := range envs {
+			if bytes.Equal(frame[80:112], env.H) {
+				order = append(order, priorities[i])
+			}
+		}
+	}
+	return order
+}
+
+func TestPriority(t *testing.T) {
+	order := dispatchOrder(t, Options{StarvationLimit: -1},
+		[]Priority{PriorityLow, PriorityNormal, PriorityHigh, PriorityLow, PriorityHigh})
+	assert.Equal(t, []Priority{PriorityHigh, PriorityHigh, PriorityNormal, PriorityLow, PriorityLow}, order)
+}
+
+func TestPriority_Starvation(t *testing.T) {
+	order := dispatchOrder(t, Options{StarvationLimit: 2},
+		[]Priority{PriorityLow, PriorityHigh, PriorityHigh, PriorityHigh, PriorityHigh, PriorityHigh})
+	assert.Equal(t, []Priority{PriorityHigh, PriorityHigh, PriorityLow, PriorityHigh, PriorityHigh, PriorityHigh}, order)
+}
+
+func TestPriorityOf(t *testing.T) {
+	assert.Equal(t, PriorityNormal, priorityOf(context.Background()))
+	assert.Equal(t, PriorityHigh, priorityOf(WithPriority(context.Background(), PriorityHigh)))
+	assert.Equal(t, PriorityHigh, priorityOf(WithPriority(context.Background(), Priority(10))))
+	assert.Equal(t, PriorityLow, priorityOf(WithPriority(context.Background(), Priority(-1))))
+}
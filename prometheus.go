@@ -0,0 +1,181 @@
+/*
+Copyright Medium Corp. 2020 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package mediumpk
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+)
+
+// promFamily is a metric family in Prometheus text format
+type promFamily struct {
+	name    string
+	help    string
+	typ     string
+	samples []promSample
+}
+
+type promSample struct {
+	suffix string
+	labels string
+	value  float64
+}
+
+func (f *promFamily) add(labels string, value float64) {
+	f.samples = append(f.samples, promSample{"", labels, value})
+}
+
+func (f *promFamily) addHistogram(labels string, h LatencyHistogram) {
+	for i, b := range h.Buckets {
+		le := strconv.FormatFloat(b.Seconds(), 'g', -1, 64)
+		f.samples = append(f.samples, promSample{"_bucket", labels + `,le="` + le + `"`, float64(h.Counts[i])})
+	}
+	f.samples = append(f.samples, promSample{"_bucket", labels + `,le="+Inf"`, float64(h.Count)})
+	f.samples = append(f.samples, promSample{"_sum", labels, h.Sum.Seconds()})
+	f.samples = append(f.samples, promSample{"_count", labels, float64(h.Count)})
+}
+
+func (f *promFamily) write(w io.Writer) {
+	if len(f.samples) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, f.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.typ)
+	for _, s := range f.samples {
+		labels := ""
+		if s.labels != "" {
+			labels = "{" + s.labels + "}"
+		}
+		fmt.Fprintf(w, "%s%s%s %s\n", f.name, s.suffix, labels, strconv.FormatFloat(s.value, 'g', -1, 64))
+	}
+}
+
+// MetricsHandler returns http.Handler exporting metrics of the default Manager in Prometheus text format
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m := defaultManager()
+		if m == nil {
+			http.Error(w, ErrManagerClosed.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		m.serveMetrics(w)
+	})
+}
+
+// MetricsHandler returns http.Handler exporting metrics of m in Prometheus text format.
+// It exports temperature, voltages and counters read from each MBPU with host side
+// pending count, available slots, emergency state and CPU fallback state.
+func (m *Manager) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.serveMetrics(w)
+	})
+}
+
+func (m *Manager) serveMetrics(w http.ResponseWriter) {
+	var buf bytes.Buffer
+	m.writeMetrics(&buf)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+func (m *Manager) writeMetrics(w io.Writer) {
+	temperature := &promFamily{name: "mbpu_temperature_celsius", help: "Temperature of MBPU.", typ: "gauge"}
+	vccint := &promFamily{name: "mbpu_vccint_volts", help: "VCCINT voltage of MBPU.", typ: "gauge"}
+	vccaux := &promFamily{name: "mbpu_vccaux_volts", help: "VCCAUX voltage of MBPU.", typ: "gauge"}
+	vccbram := &promFamily{name: "mbpu_vccbram_volts", help: "VCCBRAM voltage of MBPU.", typ: "gauge"}
+	signCount := &promFamily{name: "mbpu_sign_total", help: "Sign requests counted by MBPU.", typ: "counter"}
+	verifyCount := &promFamily{name: "mbpu_verify_total", help: "Verify requests counted by MBPU.", typ: "counter"}
+	errorCount := &promFamily{name: "mbpu_error_total", help: "Errors counted by MBPU.", typ: "counter"}
+	pending := &promFamily{name: "mbpu_pending_requests", help: "Requests sent to MBPU and waiting for response.", typ: "gauge"}
+	available := &promFamily{name: "mbpu_available_slots", help: "Slots available for new requests.", typ: "gauge"}
+	emergency := &promFamily{name: "mbpu_emergency", help: "Whether MBPU is down (1) or not (0).", typ: "gauge"}
+	throttled := &promFamily{name: "mbpu_throttled", help: "Whether MBPU is throttled for temperature (1) or not (0).", typ: "gauge"}
+	fallback := &promFamily{name: "mbpu_cpu_fallback_enabled", help: "Whether CPU fallback is on (1) or not (0).", typ: "gauge"}
+	fallbackCount := &promFamily{name: "mbpu_cpu_fallback_total", help: "Requests computed on CPU while MBPU is down.", typ: "counter"}
+	queueLength := &promFamily{name: "mbpu_queue_length", help: "Requests waiting for dispatch.", typ: "gauge"}
+	queueCapacity := &promFamily{name: "mbpu_queue_capacity", help: "Requests admission queue holds.", typ: "gauge"}
+	rejected := &promFamily{name: "mbpu_rejected_total", help: "Requests refused because admission queue was full.", typ: "counter"}
+	requests := &promFamily{name: "mbpu_requests_total", help: "Requests answered by MBPU.", typ: "counter"}
+	retries := &promFamily{name: "mbpu_retries_total", help: "Retries to put request into a slot.", typ: "counter"}
+	slotFull := &promFamily{name: "mbpu_slot_full_total", help: "Requests that waited because every slot was pending.", typ: "counter"}
+	rerouted := &promFamily{name: "mbpu_rerouted_total", help: "Requests retried on another MBPU because MBPU went down without answering.", typ: "counter"}
+	dropped := &promFamily{name: "mbpu_dropped_responses_total", help: "Response frames dropped as corrupted, stale or duplicated.", typ: "counter"}
+	checks := &promFamily{name: "mbpu_consistency_checks_total", help: "Signatures generated by MBPU verified by consistency check.", typ: "counter"}
+	mismatches := &promFamily{name: "mbpu_consistency_mismatches_total", help: "Inconsistent results found by consistency check.", typ: "counter"}
+	queueWait := &promFamily{name: "mbpu_queue_wait_seconds", help: "Time from submission until request is sent to MBPU.", typ: "histogram"}
+	roundTrip := &promFamily{name: "mbpu_round_trip_seconds", help: "Time from sending request to MBPU until its response arrives.", typ: "histogram"}
+
+	for _, mpk := range m.mpks {
+		labels := fmt.Sprintf("device=\"%d\"", mpk.index)
+
+		stats := mpk.getStats()
+		for _, op := range []struct {
+			name  string
+			stats OperationStats
+		}{{opSign.String(), stats.Sign}, {opVerify.String(), stats.Verify}} {
+			opLabels := labels + `,op="` + op.name + `"`
+			requests.add(opLabels, float64(op.stats.Requests))
+			retries.add(opLabels, float64(op.stats.Retries))
+			slotFull.add(opLabels, float64(op.stats.SlotFull))
+			rerouted.add(opLabels, float64(op.stats.Rerouted))
+			mismatches.add(opLabels, float64(op.stats.Mismatches))
+			queueWait.addHistogram(opLabels, op.stats.QueueWait)
+			roundTrip.addHistogram(opLabels, op.stats.RoundTrip)
+		}
+		checks.add(labels+`,method="device"`, float64(stats.Sign.Checks-stats.Sign.CPUChecks))
+		checks.add(labels+`,method="cpu"`, float64(stats.Sign.CPUChecks))
+		dropped.add(labels, float64(stats.Dropped))
+
+		pending.add(labels, float64(atomic.LoadInt32(&mpk.count)))
+		available.add(labels, float64(len(mpk.slots)))
+		emergency.add(labels, float64(atomic.LoadInt32(&mpk.emergency)))
+		throttled.add(labels, float64(atomic.LoadInt32(&mpk.throttled)))
+
+		env, err := mpk.getMetric()
+		if err != nil {
+			continue
+		}
+		vint, vaux, vbram := env.Voltages()
+		signs, verifies, errs := env.Counter()
+		temperature.add(labels, parseMetric(env.Temperature()))
+		vccint.add(labels, parseMetric(vint))
+		vccaux.add(labels, parseMetric(vaux))
+		vccbram.add(labels, parseMetric(vbram))
+		signCount.add(labels, float64(signs))
+		verifyCount.add(labels, float64(verifies))
+		errorCount.add(labels, float64(errs))
+	}
+
+	for _, q := range m.QueueStats() {
+		labels := `priority="` + q.Priority.String() + `"`
+		queueLength.add(labels, float64(q.Length))
+		queueCapacity.add(labels, float64(q.Capacity))
+		rejected.add(labels, float64(q.Rejected))
+	}
+
+	enabled, count := m.cpuFallbackState()
+	fallback.add("", float64(enabled))
+	fallbackCount.add("", float64(count))
+
+	for _, f := range []*promFamily{temperature, vccint, vccaux, vccbram, signCount, verifyCount, errorCount,
+		pending, available, emergency, throttled, fallback, fallbackCount, queueLength, queueCapacity, rejected, requests, retries, slotFull, rerouted, dropped, checks, mismatches, queueWait, roundTrip} {
+		f.write(w)
+	}
+}
+
+func parseMetric(v string) float64 {
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0
+	}
+	return f
+}
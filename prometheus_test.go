@@ -0,0 +1,48 @@
+package mediumpk
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	m := newTestManager(t, Options{Devices: []Device{NewSimulatorDevice(), NewSimulatorDevice()}})
+
+	workload := data[0]
+	env, err := NewVerifyRequestEnvelop(workload.qx, workload.qy, workload.r, workload.s, workload.h)
+	assert.NoError(t, err)
+	_, _, _, err = m.Request(env)
+	assert.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	m.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, 200, rec.Code)
+	assert.True(t, strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain"))
+
+	body, err := ioutil.ReadAll(rec.Body)
+	assert.NoError(t, err)
+	text := string(body)
+	assert.Contains(t, text, "# TYPE mbpu_temperature_celsius gauge\n")
+	assert.Contains(t, text, "mbpu_temperature_celsius{device=\"0\"} 41.48")
+	assert.Contains(t, text, "mbpu_vccint_volts{device=\"1\"} 0.81")
+	assert.Contains(t, text, "# TYPE mbpu_verify_total counter\n")
+	assert.Contains(t, text, "mbpu_available_slots{device=\"0\"} 64\n")
+	assert.Contains(t, text, "mbpu_pending_requests{device=\"1\"} 0\n")
+	assert.Contains(t, text, "mbpu_emergency{device=\"0\"} 0\n")
+	assert.Contains(t, text, "mbpu_cpu_fallback_enabled 0\n")
+	assert.Contains(t, text, "# TYPE mbpu_requests_total counter\n")
+	assert.Contains(t, text, "# TYPE mbpu_round_trip_seconds histogram\n")
+	assert.Contains(t, text, "mbpu_round_trip_seconds_bucket{device=\"1\",op=\"sign\",le=\"+Inf\"} 0\n")
+
+	var verifies int
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(line, "mbpu_verify_total{") && strings.HasSuffix(line, " 1") {
+			verifies++
+		}
+	}
+	assert.Equal(t, 1, verifies)
+}
@@ -0,0 +1,92 @@
+/*
+Copyright Medium Corp. 2020 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package mediumpk
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// QueueStats is the state of admission queue of a priority
+type QueueStats struct {
+	Priority Priority
+	// Length is the number of requests waiting for dispatch
+	Length int
+	// Capacity is the number of requests the queue holds, see Options.QueueDepth
+	Capacity int
+	// Rejected is the number of requests TryRequest refused because the queue was full
+	Rejected uint64
+}
+
+// TryRequest is like Request but fails fast with ErrQueueFull instead of waiting
+// when the admission queue is full.
+func (m *Manager) TryRequest(env RequestEnvelop) (int, []byte, []byte, error) {
+	return m.TryRequestContext(context.Background(), env)
+}
+
+// TryRequestContext is like RequestContext but fails fast with ErrQueueFull instead of waiting
+// when the admission queue of the priority of ctx is full.
+func (m *Manager) TryRequestContext(ctx context.Context, env RequestEnvelop) (int, []byte, []byte, error) {
+	req, err := m.trySubmit(ctx, env)
+	if err != nil {
+		return -1, []byte(nil), []byte(nil), err
+	}
+	return req.wait()
+}
+
+// trySubmit is like submit but does not wait for room in the admission queue
+func (m *Manager) trySubmit(ctx context.Context, env RequestEnvelop) (*requestWrapper, error) {
+	if m == nil {
+		return nil, ErrManagerClosed
+	}
+	m.admission.RLock()
+	defer m.admission.RUnlock()
+	if atomic.LoadInt32(&m.closed) == 1 {
+		return nil, ErrManagerClosed
+	}
+	// fields are stored canonical, CPU and consistency check take them as they are
+	env, err := env.canonical()
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	p := priorityOf(ctx)
+	req := newRequestWrapper(ctx, env)
+	select {
+	case m.queueOf(req) <- req:
+	default:
+		atomic.AddUint64(&m.rejected[p], 1)
+		return nil, ErrQueueFull
+	}
+	return req, nil
+}
+
+// queueOf returns the queue req waits in. Requests of curve no MBPU computes wait for cpu-goroutines
+// apart from dispatcher, so that they do not hold up requests for MBPUs while CPUs are busy.
+func (m *Manager) queueOf(req *requestWrapper) chan *requestWrapper {
+	if !m.curves.has(curveOf(req.env)) {
+		return m.cpuQueue
+	}
+	return m.chanRequest[priorityOf(req.ctx)]
+}
+
+// QueueStats returns the state of admission queue of each priority, from low to high
+func (m *Manager) QueueStats() []QueueStats {
+	stats := make([]QueueStats, numPriorities)
+	for p := range m.chanRequest {
+		stats[p] = QueueStats{
+			Priority: Priority(p),
+			Length:   len(m.chanRequest[p]),
+			Capacity: cap(m.chanRequest[p]),
+			Rejected: atomic.LoadUint64(&m.rejected[p]),
+		}
+	}
+	return stats
+}
@@ -0,0 +1,43 @@
+package mediumpk
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTryRequest(t *testing.T) {
+	m := newTestManager(t, Options{Devices: []Device{NewSimulatorDevice()}, MaxPending: 1, QueueDepth: 2})
+
+	env := newTestSignRequest(t)
+	result, _, _, err := m.TryRequest(env)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result)
+
+	// push-goroutine holds one waiting for the slot, dispatcher holds one and the queue holds two
+	takeSlots(m.mpks[0], 1)
+	futures := submitBlocked(m, 4, func() RequestEnvelop { return env })
+	assert.Eventually(t, func() bool { return len(m.chanRequest[PriorityNormal]) == 2 }, time.Second, time.Millisecond)
+	_, _, _, err = m.TryRequest(env)
+	assert.True(t, errors.Is(err, ErrQueueFull))
+
+	stats := m.QueueStats()
+	assert.Equal(t, 3, len(stats))
+	assert.Equal(t, PriorityNormal, stats[PriorityNormal].Priority)
+	assert.Equal(t, 2, stats[PriorityNormal].Length)
+	assert.Equal(t, 2, stats[PriorityNormal].Capacity)
+	assert.Equal(t, uint64(1), stats[PriorityNormal].Rejected)
+
+	// other priorities have their own queue
+	ctx, cancel := context.WithTimeout(WithPriority(context.Background(), PriorityHigh), 50*time.Millisecond)
+	defer cancel()
+	_, _, _, err = m.TryRequestContext(ctx, env)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	m.mpks[0].slots <- true
+	waitFutures(t, futures, 4)
+	assert.Equal(t, 0, m.QueueStats()[PriorityNormal].Length)
+}
@@ -0,0 +1,175 @@
+/*
+Copyright Medium Corp. 2020 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package mediumpk
+
+import (
+	"crypto/elliptic"
+	"math/big"
+)
+
+// secp256k1 is the curve y^2 = x^3 + 7 of SEC 2. crypto/elliptic assumes a = -3 and
+// has no such curve, so it is implemented in jacobian coordinates here.
+// It is not constant time, so it only verifies: signing with secp256k1 keys is refused, see checkSignCurve.
+var secp256k1 = newKoblitzCurve()
+
+type koblitzCurve struct {
+	params *elliptic.CurveParams
+}
+
+func newKoblitzCurve() *koblitzCurve {
+	p := &elliptic.CurveParams{Name: "secp256k1", BitSize: 256}
+	p.P, _ = new(big.Int).SetString("fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2f", 16)
+	p.N, _ = new(big.Int).SetString("fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141", 16)
+	p.B = big.NewInt(7)
+	p.Gx, _ = new(big.Int).SetString("79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798", 16)
+	p.Gy, _ = new(big.Int).SetString("483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8", 16)
+	return &koblitzCurve{p}
+}
+
+func (c *koblitzCurve) Params() *elliptic.CurveParams {
+	return c.params
+}
+
+func (c *koblitzCurve) IsOnCurve(x, y *big.Int) bool {
+	P := c.params.P
+	if x.Sign() < 0 || x.Cmp(P) >= 0 || y.Sign() < 0 || y.Cmp(P) >= 0 {
+		return false
+	}
+	// y^2 = x^3 + b
+	y2 := new(big.Int).Mul(y, y)
+	y2.Mod(y2, P)
+	x3 := new(big.Int).Mul(x, x)
+	x3.Mul(x3, x)
+	x3.Add(x3, c.params.B)
+	x3.Mod(x3, P)
+	return x3.Cmp(y2) == 0
+}
+
+// jacobianPoint is (X/Z^2, Y/Z^3), Z = 0 for the point at infinity
+type jacobianPoint struct {
+	x, y, z *big.Int
+}
+
+func (c *koblitzCurve) toJacobian(x, y *big.Int) jacobianPoint {
+	if x.Sign() == 0 && y.Sign() == 0 {
+		return jacobianPoint{new(big.Int), new(big.Int), new(big.Int)}
+	}
+	return jacobianPoint{new(big.Int).Set(x), new(big.Int).Set(y), big.NewInt(1)}
+}
+
+func (c *koblitzCurve) toAffine(p jacobianPoint) (*big.Int, *big.Int) {
+	if p.z.Sign() == 0 {
+		return new(big.Int), new(big.Int)
+	}
+	P := c.params.P
+	zInv := new(big.Int).ModInverse(p.z, P)
+	zInv2 := new(big.Int).Mul(zInv, zInv)
+	x := new(big.Int).Mul(p.x, zInv2)
+	x.Mod(x, P)
+	y := new(big.Int).Mul(p.y, zInv2.Mul(zInv2, zInv))
+	y.Mod(y, P)
+	return x, y
+}
+
+// double returns 2p, dbl-2009-l for a = 0
+func (c *koblitzCurve) double(p jacobianPoint) jacobianPoint {
+	if p.z.Sign() == 0 || p.y.Sign() == 0 {
+		return jacobianPoint{new(big.Int), new(big.Int), new(big.Int)}
+	}
+	P := c.params.P
+	a := new(big.Int).Mul(p.x, p.x)
+	b := new(big.Int).Mul(p.y, p.y)
+	cc := new(big.Int).Mul(b, b)
+	// d = 2*((x+b)^2-a-c)
+	d := new(big.Int).Add(p.x, b)
+	d.Mul(d, d).Sub(d, a).Sub(d, cc).Lsh(d, 1).Mod(d, P)
+	e := new(big.Int).Lsh(a, 1)
+	e.Add(e, a)
+	f := new(big.Int).Mul(e, e)
+
+	x3 := new(big.Int).Sub(f, new(big.Int).Lsh(d, 1))
+	x3.Mod(x3, P)
+	y3 := new(big.Int).Sub(d, x3)
+	y3.Mul(y3, e).Sub(y3, cc.Lsh(cc, 3)).Mod(y3, P)
+	z3 := new(big.Int).Mul(p.y, p.z)
+	z3.Lsh(z3, 1).Mod(z3, P)
+	return jacobianPoint{x3, y3, z3}
+}
+
+// add returns p+q, add-2007-bl
+func (c *koblitzCurve) add(p, q jacobianPoint) jacobianPoint {
+	if p.z.Sign() == 0 {
+		return q
+	}
+	if q.z.Sign() == 0 {
+		return p
+	}
+	P := c.params.P
+	z1z1 := new(big.Int).Mul(p.z, p.z)
+	z1z1.Mod(z1z1, P)
+	z2z2 := new(big.Int).Mul(q.z, q.z)
+	z2z2.Mod(z2z2, P)
+	u1 := new(big.Int).Mul(p.x, z2z2)
+	u1.Mod(u1, P)
+	u2 := new(big.Int).Mul(q.x, z1z1)
+	u2.Mod(u2, P)
+	s1 := new(big.Int).Mul(p.y, q.z)
+	s1.Mul(s1, z2z2).Mod(s1, P)
+	s2 := new(big.Int).Mul(q.y, p.z)
+	s2.Mul(s2, z1z1).Mod(s2, P)
+
+	h := new(big.Int).Sub(u2, u1)
+	h.Mod(h, P)
+	r := new(big.Int).Sub(s2, s1)
+	r.Mod(r, P)
+	if h.Sign() == 0 {
+		if r.Sign() == 0 {
+			return c.double(p)
+		}
+		return jacobianPoint{new(big.Int), new(big.Int), new(big.Int)}
+	}
+	r.Lsh(r, 1)
+
+	i := new(big.Int).Lsh(h, 1)
+	i.Mul(i, i)
+	j := new(big.Int).Mul(h, i)
+	v := new(big.Int).Mul(u1, i)
+
+	x3 := new(big.Int).Mul(r, r)
+	x3.Sub(x3, j).Sub(x3, new(big.Int).Lsh(v, 1)).Mod(x3, P)
+	y3 := new(big.Int).Sub(v, x3)
+	y3.Mul(y3, r).Sub(y3, s1.Mul(s1, j).Lsh(s1, 1)).Mod(y3, P)
+	z3 := new(big.Int).Add(p.z, q.z)
+	z3.Mul(z3, z3).Sub(z3, z1z1).Sub(z3, z2z2).Mul(z3, h).Mod(z3, P)
+	return jacobianPoint{x3, y3, z3}
+}
+
+func (c *koblitzCurve) Add(x1, y1, x2, y2 *big.Int) (*big.Int, *big.Int) {
+	return c.toAffine(c.add(c.toJacobian(x1, y1), c.toJacobian(x2, y2)))
+}
+
+func (c *koblitzCurve) Double(x1, y1 *big.Int) (*big.Int, *big.Int) {
+	return c.toAffine(c.double(c.toJacobian(x1, y1)))
+}
+
+func (c *koblitzCurve) ScalarMult(x1, y1 *big.Int, k []byte) (*big.Int, *big.Int) {
+	base := c.toJacobian(x1, y1)
+	p := jacobianPoint{new(big.Int), new(big.Int), new(big.Int)}
+	for _, b := range k {
+		for bit := 7; bit >= 0; bit-- {
+			p = c.double(p)
+			if b>>uint(bit)&1 == 1 {
+				p = c.add(p, base)
+			}
+		}
+	}
+	return c.toAffine(p)
+}
+
+func (c *koblitzCurve) ScalarBaseMult(k []byte) (*big.Int, *big.Int) {
+	return c.ScalarMult(c.params.Gx, c.params.Gy, k)
+}
@@ -11,40 +11,79 @@ import (
 
 type serializer struct{}
 
-func (s *serializer) serializeSignRequest(env SignRequestEnvelop, userctx int) []byte {
-	tmp := make([]byte, internal.SignRequestSize)
-	binary.BigEndian.PutUint64(tmp[0:], 12297829379609722880)
+// leftPad returns b left-padded with zeros to size bytes, b longer than size is an error
+func leftPad(b []byte, size int) ([]byte, error) {
+	if len(b) > size {
+		return nil, fmt.Errorf("length %d exceeds %d bytes", len(b), size)
+	}
+	padded := make([]byte, size)
+	copy(padded[size-len(b):], b)
+	return padded, nil
+}
+
+// checkFrameCurve returns error if fields of c do not fit in request frame
+func checkFrameCurve(c Curve) error {
+	if c.size() > frameFieldSize {
+		return fmt.Errorf("%w: %v is not computed by mbpu", ErrInvalidEnvelop, c)
+	}
+	return nil
+}
+
+// putFields copies fields left-padded to frameFieldSize into frame from offset 16
+func putFields(frame []byte, fields ...[]byte) {
+	i := 16
+	for _, field := range fields {
+		i += frameFieldSize
+		copy(frame[i-len(field):i], field)
+	}
+}
 
-	var i int = 16
+func (s *serializer) serializeSignRequest(env SignRequestEnvelop, userctx int) ([]byte, error) {
+	env, err := NewCurveSignRequestEnvelop(env.Curve, env.D, env.K, env.H)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkFrameCurve(env.Curve); err != nil {
+		return nil, err
+	}
+
+	tmp := make([]byte, internal.SignRequestSize)
+	binary.BigEndian.PutUint64(tmp[0:], internal.SignRequestHeader|uint64(env.Curve))
 	binary.BigEndian.PutUint64(tmp[8:], uint64(userctx))
-	i += copy(tmp[i:], env.D)
-	i += copy(tmp[i:], env.K)
-	i += copy(tmp[i:], env.H)
+	putFields(tmp, env.D, env.K, env.H)
 
-	return tmp
+	return tmp, nil
 }
 
-func (s *serializer) serializeVerifyRequest(env VerifyRequestEnvelop, userctx int) []byte {
+func (s *serializer) serializeVerifyRequest(env VerifyRequestEnvelop, userctx int) ([]byte, error) {
+	env, err := NewCurveVerifyRequestEnvelop(env.Curve, env.Qx, env.Qy, env.R, env.S, env.H)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkFrameCurve(env.Curve); err != nil {
+		return nil, err
+	}
+
 	tmp := make([]byte, internal.VerifyRequestSize)
 
-	binary.BigEndian.PutUint64(tmp[0:8], 13527612317570695168)
+	binary.BigEndian.PutUint64(tmp[0:8], internal.VerifyRequestHeader|uint64(env.Curve))
 	binary.BigEndian.PutUint64(tmp[8:16], uint64(userctx))
+	putFields(tmp, env.Qx, env.Qy, env.R, env.S, env.H)
 
-	var i int = 16
-	i += copy(tmp[i:], env.Qx)
-	i += copy(tmp[i:], env.Qy)
-	i += copy(tmp[i:], env.R)
-	i += copy(tmp[i:], env.S)
-	i += copy(tmp[i:], env.H)
-
-	return tmp
+	return tmp, nil
 }
 
 type deserializer struct{}
 
 func (s *deserializer) deserializeResponse(env *ResponseEnvelop, buffer []byte) (int, error) {
 	if len(buffer) != internal.ResponseSize {
-		return 0, errors.New("wrong responseEnvelopSize : " + strconv.Itoa(len(buffer)))
+		return 0, fmt.Errorf("%w: wrong responseEnvelopSize : %d", ErrMalformedResponse, len(buffer))
+	}
+
+	// header of verify response is not known, so only sign response is told by its header
+	env.op = opVerify
+	if binary.BigEndian.Uint32(buffer[0:4]) == internal.SignResponseHeader {
+		env.op = opSign
 	}
 
 	env.result = int(binary.BigEndian.Uint32(buffer[4:8]))
@@ -1,7 +1,9 @@
 package mediumpk
 
 import (
+	"crypto/elliptic"
 	"encoding/hex"
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -43,6 +45,7 @@ func TestSerializeSignRequest(t *testing.T) {
 		d32,
 		k32,
 		h32,
+		CurveP256,
 	}
 
 	// expected
@@ -56,7 +59,8 @@ func TestSerializeSignRequest(t *testing.T) {
 
 	// serialize envelop
 	serializer := serializer{}
-	serialized := serializer.serializeSignRequest(env, 16)
+	serialized, err := serializer.serializeSignRequest(env, 16)
+	assert.NoError(t, err)
 	assert.Equal(t, expected, serialized)
 }
 
@@ -94,6 +98,7 @@ func TestSerializeVerifyRequest(t *testing.T) {
 		r32,
 		s32,
 		h32,
+		CurveP256,
 	}
 
 	// expected
@@ -109,10 +114,68 @@ func TestSerializeVerifyRequest(t *testing.T) {
 
 	// serialize envelop
 	serializer := serializer{}
-	serialized := serializer.serializeVerifyRequest(env, 16)
+	serialized, err := serializer.serializeVerifyRequest(env, 16)
+	assert.NoError(t, err)
 	assert.Equal(t, expected, serialized)
 }
 
+func TestSerializeVerifyRequest_Padding(t *testing.T) {
+	x, _ := hex.DecodeString(strX)
+	y, _ := hex.DecodeString(strY)
+	r, _ := hex.DecodeString(strR)
+	s, _ := hex.DecodeString(strS)
+	h, _ := hex.DecodeString(strH2)
+
+	serializer := serializer{}
+	expected, err := serializer.serializeVerifyRequest(VerifyRequestEnvelop{x, y, r, s, h, CurveP256}, 16)
+	assert.NoError(t, err)
+
+	// leading zeros of hash are dropped as big.Int.Bytes() does
+	serialized, err := serializer.serializeVerifyRequest(VerifyRequestEnvelop{x, y, r, s, h[21:], CurveP256}, 16)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, serialized)
+}
+
+func TestSerializeRequest_Invalid(t *testing.T) {
+	d, _ := hex.DecodeString(strD)
+	k, _ := hex.DecodeString(strK)
+	h, _ := hex.DecodeString(strH1)
+	x, _ := hex.DecodeString(strX)
+	y, _ := hex.DecodeString(strY)
+	r, _ := hex.DecodeString(strR)
+	s, _ := hex.DecodeString(strS)
+	n := elliptic.P256().Params().N.Bytes()
+	p := elliptic.P256().Params().P.Bytes()
+	zero := make([]byte, 32)
+	long := append([]byte{1}, h...)
+
+	serializer := serializer{}
+	signEnvs := []SignRequestEnvelop{
+		{zero, k, h, CurveP256},
+		{d, n, h, CurveP256},
+		{d, k, long, CurveP256},
+		{nil, k, h, CurveP256},
+	}
+	for _, env := range signEnvs {
+		_, err := serializer.serializeSignRequest(env, 16)
+		assert.True(t, errors.Is(err, ErrInvalidEnvelop))
+		assert.True(t, errors.Is(env.Validate(), ErrInvalidEnvelop))
+	}
+
+	verifyEnvs := []VerifyRequestEnvelop{
+		{p, y, r, s, h, CurveP256},
+		{x, long, r, s, h, CurveP256},
+		{x, y, zero, s, h, CurveP256},
+		{x, y, r, n, h, CurveP256},
+		{x, y, r, s, long, CurveP256},
+	}
+	for _, env := range verifyEnvs {
+		_, err := serializer.serializeVerifyRequest(env, 16)
+		assert.True(t, errors.Is(err, ErrInvalidEnvelop))
+		assert.True(t, errors.Is(env.Validate(), ErrInvalidEnvelop))
+	}
+}
+
 func TestDeserializeResponse(t *testing.T) {
 	bufStr := "0000aaaa000000000000000000000abc6c0f55fd455d34ac67ca2d987c5b50e795ec0e5eeacfb0bbf3cfdb2a428e17ac84a6603b1e0b5b577b97ba529bd1e1aa758e299e616bbe6fb2e2fd6b5ed4737400000000000000000000000000000000"
 	buffer, err := hex.DecodeString(bufStr)
@@ -125,8 +188,15 @@ func TestDeserializeResponse(t *testing.T) {
 	userctx, err := deserializer.deserializeResponse(&env, buffer)
 	assert.NoError(t, err)
 	assert.Equal(t, 0xabc, userctx)
+	assert.Equal(t, opSign, env.op)
 	assert.Equal(t, buffer[16:48], env.r)
 	assert.Equal(t, buffer[48:80], env.s)
+
+	// header other than that of sign response is taken as verify response
+	buffer[2] = 0xcc
+	_, err = deserializer.deserializeResponse(&env, buffer)
+	assert.NoError(t, err)
+	assert.Equal(t, opVerify, env.op)
 }
 
 func TestDeserializeMetric(t *testing.T) {
@@ -0,0 +1,104 @@
+/*
+Copyright Medium Corp. 2020 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package mediumpk
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultShutdownTimeout is how long Close waits for outstanding responses
+	defaultShutdownTimeout = 10 * time.Second
+	// abortTimeout is how long Shutdown waits for goroutines to give up outstanding requests after ctx is done
+	abortTimeout = 100 * time.Millisecond
+)
+
+// Close shuts m down waiting outstanding responses for 10 seconds at most, see Shutdown
+func (m *Manager) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultShutdownTimeout)
+	defer cancel()
+	return m.Shutdown(ctx)
+}
+
+// Shutdown stops admitting requests, new requests fail with ErrManagerClosed, and so do requests
+// still queued for dispatch. It waits for responses of requests sent to MBPUs until ctx is done,
+// then closes devices and metric sockets. Requests whose response has not arrived by then fail with
+// ErrManagerClosed and ctx.Err() is returned. Goroutines blocked on device may outlive Shutdown then.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.closeOnce.Do(func() {
+		atomic.StoreInt32(&m.closed, 1)
+		close(m.closing)
+		// wait for requesters which got past the closed check to queue or give up
+		m.admission.Lock()
+		m.admission.Unlock()
+		close(m.stopDispatch)
+		m.logger.Debug("MBPUManager stopped admitting requests")
+
+		go func() {
+			m.wg.Wait()
+			m.logger.Info("MBPUManager closed")
+			close(m.done)
+		}()
+	})
+
+	select {
+	case <-m.done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	m.abortOnce.Do(func() {
+		close(m.abort)
+	})
+	select {
+	case <-m.done:
+	case <-time.After(abortTimeout):
+		m.logger.Warn("MBPUManager is still closing after shutdown gave up")
+		return ctx.Err()
+	}
+	if atomic.LoadInt32(&m.abandoned) == 1 {
+		return ctx.Err()
+	}
+	return nil
+}
+
+// drainQueue answers requests left in admission queue and cpuQueue with ErrManagerClosed
+func (mgr *Manager) drainQueue() {
+	for _, ch := range append(mgr.chanRequest[:], mgr.cpuQueue) {
+		for {
+			select {
+			case req := <-ch:
+				req.notify(ResponseEnvelop{result: -1, err: ErrManagerClosed})
+				continue
+			default:
+			}
+			break
+		}
+	}
+}
+
+// waitResponses waits until polling-goroutine receives every outstanding response or shutdown is aborted.
+// Requests left pending on abort are answered with ErrManagerClosed.
+func (m *Mediumpk) waitResponses(pollDone <-chan struct{}) {
+	select {
+	case <-pollDone:
+		return
+	case <-m.manager.abort:
+	}
+
+	abandoned := 0
+	m.clearChanStore(func(req *requestWrapper) {
+		req.notify(ResponseEnvelop{result: -1, err: ErrManagerClosed})
+		abandoned++
+	})
+	if abandoned > 0 {
+		atomic.StoreInt32(&m.manager.abandoned, 1)
+		m.logger.Warn("MBPU closed before responses arrived", "abandoned", abandoned)
+	}
+}
@@ -0,0 +1,150 @@
+package mediumpk
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// stuckDevice is a SimulatorDevice whose responses are held until release is closed or poll is canceled
+// once it is stuck
+type stuckDevice struct {
+	*SimulatorDevice
+	stuck   int32
+	release chan struct{}
+}
+
+func newStuckDevice() *stuckDevice {
+	return &stuckDevice{SimulatorDevice: NewSimulatorDevice(), release: make(chan struct{})}
+}
+
+func (d *stuckDevice) Poll() ([]byte, error) {
+	if atomic.LoadInt32(&d.stuck) == 1 {
+		select {
+		case <-d.release:
+		case <-d.closed:
+		case <-d.pollCanceled():
+		}
+	}
+	return d.SimulatorDevice.Poll()
+}
+
+// submitStuck submits n requests to m and waits until they are sent to MBPU
+func submitStuck(t *testing.T, m *Manager, n int) []*Future {
+	futures := make([]*Future, n)
+	for i := range futures {
+		futures[i] = m.Submit(context.Background(), newTestSignRequest(t))
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&m.mpks[0].count) != int32(n) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, int32(n), atomic.LoadInt32(&m.mpks[0].count))
+	return futures
+}
+
+func TestShutdown(t *testing.T) {
+	dev := newStuckDevice()
+	m := newTestManager(t, Options{Devices: []Device{dev}})
+	atomic.StoreInt32(&dev.stuck, 1)
+
+	futures := submitStuck(t, m, 3)
+
+	shutdown := make(chan error)
+	go func() {
+		shutdown <- m.Shutdown(context.Background())
+	}()
+
+	// new requests are refused while outstanding ones are waited for
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&m.closed) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	_, _, _, err := m.Request(newTestSignRequest(t))
+	assert.True(t, errors.Is(err, ErrManagerClosed))
+	_, _, _, err = m.TryRequest(newTestSignRequest(t))
+	assert.True(t, errors.Is(err, ErrManagerClosed))
+
+	close(dev.release)
+	assert.NoError(t, <-shutdown)
+	for _, f := range futures {
+		result, _, _, err := f.Wait()
+		assert.NoError(t, err)
+		assert.Equal(t, 0, result)
+	}
+
+	// metric socket is closed
+	_, err = net.Dial("unix", m.mpks[0].socketAddr)
+	assert.Error(t, err)
+	assert.NoError(t, m.Shutdown(context.Background()))
+}
+
+func TestShutdown_Deadline(t *testing.T) {
+	dev := newStuckDevice()
+	m := newTestManager(t, Options{Devices: []Device{dev}})
+	atomic.StoreInt32(&dev.stuck, 1)
+
+	futures := submitStuck(t, m, 3)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.True(t, errors.Is(m.Shutdown(ctx), context.DeadlineExceeded))
+	for _, f := range futures {
+		_, _, _, err := f.Wait()
+		assert.True(t, errors.Is(err, ErrManagerClosed))
+	}
+}
+
+func TestShutdown_SilentDevice(t *testing.T) {
+	dev := newStuckDevice()
+	m := newTestManager(t, Options{Devices: []Device{dev}, MaxPending: 1})
+	atomic.StoreInt32(&dev.stuck, 1)
+
+	// one in the slot, one held by push-goroutine waiting for the slot, one by dispatcher, one queued
+	futures := submitBlocked(m, 4, func() RequestEnvelop { return newTestSignRequest(t) })
+	deadline := time.Now().Add(5 * time.Second)
+	for len(m.chanRequest[PriorityNormal]) != 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, 1, len(m.chanRequest[PriorityNormal]))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	assert.True(t, errors.Is(m.Shutdown(ctx), context.DeadlineExceeded))
+	assert.True(t, time.Since(start) < time.Second, "shutdown took %v", time.Since(start))
+	for i := 0; i < 4; i++ {
+		_, _, _, err := (<-futures).Wait()
+		assert.True(t, errors.Is(err, ErrManagerClosed))
+	}
+}
+
+func TestShutdown_ConcurrentRequests(t *testing.T) {
+	m := newTestManager(t, Options{Devices: []Device{NewSimulatorDevice(), NewSimulatorDevice()}})
+	env := newTestSignRequest(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				_, _, _, err := m.Request(env)
+				if err != nil {
+					assert.True(t, errors.Is(err, ErrManagerClosed))
+					return
+				}
+			}
+		}()
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	assert.NoError(t, m.Shutdown(context.Background()))
+	wg.Wait()
+}
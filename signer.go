@@ -0,0 +1,99 @@
+/*
+Copyright Medium Corp. 2020 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package mediumpk
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"encoding/asn1"
+	"errors"
+	"io"
+	"math/big"
+)
+
+// Signer is a crypto.Signer that generates ECDSA signatures with MBPU.
+// It can be used as tls.Certificate.PrivateKey, x509 signer or JWT signing key.
+type Signer struct {
+	priv    *ecdsa.PrivateKey
+	curve   Curve
+	manager *Manager
+}
+
+var _ crypto.Signer = (*Signer)(nil)
+
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+// NewSigner returns Signer for private key priv which signs with the default Manager.
+// priv must be of P-224, P-256, P-384 or P-521.
+func NewSigner(priv *ecdsa.PrivateKey) (*Signer, error) {
+	return newSigner(priv, nil)
+}
+
+// NewSigner returns Signer for private key priv which signs with m.
+// priv must be of P-224, P-256, P-384 or P-521.
+func (m *Manager) NewSigner(priv *ecdsa.PrivateKey) (*Signer, error) {
+	return newSigner(priv, m)
+}
+
+func newSigner(priv *ecdsa.PrivateKey, m *Manager) (*Signer, error) {
+	if priv == nil || priv.D == nil {
+		return nil, errors.New("private key is empty")
+	}
+	curve, err := CurveOf(priv.Curve)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkSignCurve(curve); err != nil {
+		return nil, err
+	}
+	return &Signer{priv, curve, m}, nil
+}
+
+// Public returns the public key corresponding to the private key
+func (s *Signer) Public() crypto.PublicKey {
+	return &s.priv.PublicKey
+}
+
+// Sign signs digest with MBPU and returns ASN.1 DER encoded signature.
+// rand is not used, k is generated by CreateRandomKCurve. opts is not used either,
+// digest longer than the order of curve is truncated as ecdsa does.
+func (s *Signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return s.SignContext(context.Background(), digest)
+}
+
+// SignContext is like Sign but gives up when ctx is done
+func (s *Signer) SignContext(ctx context.Context, digest []byte) ([]byte, error) {
+	d := s.priv.D.Bytes()
+	k, err := CreateRandomKCurve(s.curve, d, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	// ecdsa uses leftmost bits of digest as many as the order of curve
+	if size := s.curve.size(); len(digest) > size {
+		digest = digest[:size]
+	}
+
+	env, err := NewCurveSignRequestEnvelop(s.curve, d, k, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	m := s.manager
+	if m == nil {
+		m = defaultManager()
+	}
+	_, r, sig, err := m.RequestContext(ctx, env)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(ecdsaSignature{new(big.Int).SetBytes(r), new(big.Int).SetBytes(sig)})
+}
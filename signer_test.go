@@ -0,0 +1,69 @@
+package mediumpk
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSigner_Sign(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	signer, err := NewSigner(priv)
+	assert.NoError(t, err)
+	assert.Equal(t, &priv.PublicKey, signer.Public())
+
+	h256 := sha256.Sum256([]byte("Hello World"))
+	h512 := sha512.Sum512([]byte("Hello World"))
+	for _, digest := range [][]byte{h256[:], h512[:], h256[:20]} {
+		der, err := signer.Sign(rand.Reader, digest, crypto.SHA256)
+		assert.NoError(t, err)
+
+		var sig ecdsaSignature
+		_, err = asn1.Unmarshal(der, &sig)
+		assert.NoError(t, err)
+		assert.True(t, ecdsa.Verify(&priv.PublicKey, digest, sig.R, sig.S))
+	}
+}
+
+func TestSigner_CreateCertificate(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	signer, err := NewSigner(priv)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "mbpu"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, signer.Public(), signer)
+	assert.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	assert.NoError(t, cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature))
+}
+
+func TestNewSigner_Curve(t *testing.T) {
+	// curve params of P-256 is not the curve implementation
+	priv, err := ecdsa.GenerateKey(elliptic.P256().Params(), rand.Reader)
+	assert.NoError(t, err)
+
+	_, err = NewSigner(priv)
+	assert.True(t, errors.Is(err, ErrUnsupportedCurve))
+}
@@ -0,0 +1,262 @@
+/*
+Copyright Medium Corp. 2020 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package mediumpk
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/the-medium/mediumpk/internal"
+)
+
+const (
+	// SimulatorVersion is the version reported by SimulatorDevice
+	SimulatorVersion uint32 = 0x5100001
+
+	simulatorFIFODepth = 4096
+	// simulatorVerifyResponseHeader is the first 4 bytes of verify response of SimulatorDevice.
+	// That of MBPU is not known, anything but internal.SignResponseHeader is taken as verify response.
+	simulatorVerifyResponseHeader uint32 = 0x0000bbbb
+
+	// raw sensor values, about 41.5 celsius, 0.82V, 1.81V, 0.82V
+	simTemperature = 0xa0ec
+	simVccint      = 0x45da
+	simVccaux      = 0x9a7a
+	simVccbram     = 0x45e2
+)
+
+var (
+	errSimulatorClosed = errors.New("simulator device is closed")
+	errPollCanceled    = errors.New("simulator poll is canceled")
+)
+
+// SimulatorDevice is an in-memory software MBPU.
+// It parses request frames, computes sign/verify on CPU with computeCPU and returns response frames
+// the same way a MBPU does, so that the manager can run without a card. Besides P-256 it computes
+// curves fitting in frame, taking curve from request header as Options.DeviceCurves describes.
+type SimulatorDevice struct {
+	c2h         chan []byte
+	closed      chan struct{}
+	closeOnce   sync.Once
+	mutex       sync.Mutex
+	canceled    chan struct{} // closed by CancelPoll, replaced by Reset
+	signCount   uint32
+	verifyCount uint32
+	errorCount  uint32
+}
+
+// NewSimulatorDevice returns SimulatorDevice instance
+func NewSimulatorDevice() *SimulatorDevice {
+	return &SimulatorDevice{
+		c2h:      make(chan []byte, simulatorFIFODepth),
+		closed:   make(chan struct{}),
+		canceled: make(chan struct{}),
+	}
+}
+
+// Request computes the request frame and queues the response frame
+func (d *SimulatorDevice) Request(buffer []byte) error {
+	select {
+	case <-d.closed:
+		return errSimulatorClosed
+	default:
+	}
+
+	if len(buffer) < 16 {
+		return errors.New("request size too small.." + strconv.Itoa(len(buffer)))
+	}
+
+	header := binary.BigEndian.Uint64(buffer[0:8])
+	curve := Curve(uint32(header))
+	if !frameCurves.has(curve) {
+		return fmt.Errorf("unknown curve 0x%x", uint32(header))
+	}
+
+	var resp []byte
+	switch header &^ 0xffffffff {
+	case internal.SignRequestHeader:
+		if len(buffer) != internal.SignRequestSize {
+			return errors.New("write size not match.." + strconv.Itoa(len(buffer)))
+		}
+		resp = d.sign(curve, buffer)
+	case internal.VerifyRequestHeader:
+		if len(buffer) != internal.VerifyRequestSize {
+			return errors.New("write size not match.." + strconv.Itoa(len(buffer)))
+		}
+		resp = d.verify(curve, buffer)
+	default:
+		return fmt.Errorf("unknown request header 0x%x", buffer[0:8])
+	}
+
+	select {
+	case d.c2h <- resp:
+		return nil
+	default:
+		return errors.New("c2h fifo is full")
+	}
+}
+
+// Poll brings response frame, it blocks until a response is ready, device is closed or poll is canceled
+func (d *SimulatorDevice) Poll() ([]byte, error) {
+	canceled := d.pollCanceled()
+	select {
+	case <-canceled:
+		return nil, errPollCanceled
+	default:
+	}
+
+	select {
+	case buffer := <-d.c2h:
+		return buffer, nil
+	case <-d.closed:
+		return nil, errSimulatorClosed
+	case <-canceled:
+		return nil, errPollCanceled
+	}
+}
+
+// CancelPoll makes blocked Poll return error, Poll fails until Reset
+func (d *SimulatorDevice) CancelPoll() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	select {
+	case <-d.canceled:
+	default:
+		close(d.canceled)
+	}
+	return nil
+}
+
+// pollCanceled returns channel closed by CancelPoll
+func (d *SimulatorDevice) pollCanceled() <-chan struct{} {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.canceled
+}
+
+// GetMetrics returns device metric information in MBPU register layout
+func (d *SimulatorDevice) GetMetrics() ([]byte, error) {
+	buffer := make([]byte, internal.MetricSetSize)
+	binary.LittleEndian.PutUint32(buffer[0:4], simTemperature)
+	binary.LittleEndian.PutUint32(buffer[4:8], simVccint)
+	binary.LittleEndian.PutUint32(buffer[8:12], simVccaux)
+	binary.LittleEndian.PutUint32(buffer[12:16], simVccbram)
+
+	d.mutex.Lock()
+	binary.LittleEndian.PutUint32(buffer[16:20], d.signCount)
+	binary.LittleEndian.PutUint32(buffer[20:24], d.verifyCount)
+	binary.LittleEndian.PutUint32(buffer[24:28], d.errorCount)
+	d.mutex.Unlock()
+
+	return buffer, nil
+}
+
+// Reset drops queued responses, clears counters and undoes CancelPoll
+func (d *SimulatorDevice) Reset() error {
+drain:
+	for {
+		select {
+		case <-d.c2h:
+		default:
+			break drain
+		}
+	}
+
+	d.mutex.Lock()
+	d.signCount, d.verifyCount, d.errorCount = 0, 0, 0
+	select {
+	case <-d.canceled:
+		d.canceled = make(chan struct{})
+	default:
+	}
+	d.mutex.Unlock()
+
+	return nil
+}
+
+// Version returns SimulatorVersion in the same format as MBPU
+func (d *SimulatorDevice) Version() (string, error) {
+	return fmt.Sprintf("%x\n", SimulatorVersion), nil
+}
+
+// CheckAvailable returns error if device is closed
+func (d *SimulatorDevice) CheckAvailable() error {
+	select {
+	case <-d.closed:
+		return errSimulatorClosed
+	default:
+		return nil
+	}
+}
+
+// Close closes device, blocked Poll returns error
+func (d *SimulatorDevice) Close() error {
+	d.closeOnce.Do(func() {
+		close(d.closed)
+	})
+	return nil
+}
+
+// frameField returns i-th field of request frame, trimmed to the size of curve
+func frameField(curve Curve, buffer []byte, i int) []byte {
+	end := 16 + (i+1)*frameFieldSize
+	return buffer[end-curve.size() : end]
+}
+
+func (d *SimulatorDevice) sign(curve Curve, buffer []byte) []byte {
+	res := computeCPU(SignRequestEnvelop{
+		D:     frameField(curve, buffer, 0),
+		K:     frameField(curve, buffer, 1),
+		H:     frameField(curve, buffer, 2),
+		Curve: curve,
+	})
+	if res.result == resultOK {
+		d.count(&d.signCount)
+	} else {
+		d.count(&d.errorCount)
+	}
+
+	return newSimulatorResponse(internal.SignResponseHeader, buffer, res)
+}
+
+func (d *SimulatorDevice) verify(curve Curve, buffer []byte) []byte {
+	res := computeCPU(VerifyRequestEnvelop{
+		Qx:    frameField(curve, buffer, 0),
+		Qy:    frameField(curve, buffer, 1),
+		R:     frameField(curve, buffer, 2),
+		S:     frameField(curve, buffer, 3),
+		H:     frameField(curve, buffer, 4),
+		Curve: curve,
+	})
+	if res.result == resultOK {
+		d.count(&d.verifyCount)
+	} else {
+		d.count(&d.errorCount)
+	}
+
+	return newSimulatorResponse(simulatorVerifyResponseHeader, buffer, res)
+}
+
+func (d *SimulatorDevice) count(counter *uint32) {
+	d.mutex.Lock()
+	*counter++
+	d.mutex.Unlock()
+}
+
+// newSimulatorResponse returns response frame of res with header and userctx of request
+func newSimulatorResponse(header uint32, request []byte, res ResponseEnvelop) []byte {
+	resp := make([]byte, internal.ResponseSize)
+	binary.BigEndian.PutUint32(resp[0:4], header)
+	binary.BigEndian.PutUint32(resp[4:8], uint32(res.result))
+	copy(resp[8:16], request[8:16])
+	copy(resp[48-len(res.r):48], res.r)
+	copy(resp[80-len(res.s):80], res.s)
+	return resp
+}
@@ -0,0 +1,85 @@
+package mediumpk
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/the-medium/mediumpk/internal"
+)
+
+func TestSimulatorDevice_Sign(t *testing.T) {
+	d, _ := hex.DecodeString(strD)
+	k, _ := hex.DecodeString(strK)
+	h, _ := hex.DecodeString(strH1)
+
+	dev := NewSimulatorDevice()
+	defer dev.Close()
+
+	s := serializer{}
+	buffer, err := s.serializeSignRequest(SignRequestEnvelop{d, k, h, CurveP256}, 0xabc)
+	assert.NoError(t, err)
+	assert.NoError(t, dev.Request(buffer))
+
+	resp, err := dev.Poll()
+	assert.NoError(t, err)
+	assert.Equal(t, internal.ResponseSize, len(resp))
+	assert.Equal(t, internal.SignResponseHeader, binary.BigEndian.Uint32(resp[0:4]))
+	assert.Equal(t, uint32(0), binary.BigEndian.Uint32(resp[4:8]))
+	assert.Equal(t, uint64(0xabc), binary.BigEndian.Uint64(resp[8:16]))
+}
+
+func TestSimulatorDevice_Verify(t *testing.T) {
+	x, _ := hex.DecodeString(strX)
+	y, _ := hex.DecodeString(strY)
+	r, _ := hex.DecodeString(strR)
+	sig, _ := hex.DecodeString(strS)
+	h, _ := hex.DecodeString(strH2)
+
+	dev := NewSimulatorDevice()
+	defer dev.Close()
+
+	s := serializer{}
+	buffer, err := s.serializeVerifyRequest(VerifyRequestEnvelop{x, y, r, sig, h, CurveP256}, 1)
+	assert.NoError(t, err)
+	assert.NoError(t, dev.Request(buffer))
+	h[31] ^= 0xff
+	buffer, err = s.serializeVerifyRequest(VerifyRequestEnvelop{x, y, r, sig, h, CurveP256}, 2)
+	assert.NoError(t, err)
+	assert.NoError(t, dev.Request(buffer))
+
+	resp, err := dev.Poll()
+	assert.NoError(t, err)
+	assert.Equal(t, simulatorVerifyResponseHeader, binary.BigEndian.Uint32(resp[0:4]))
+	assert.Equal(t, uint32(0), binary.BigEndian.Uint32(resp[4:8]))
+	assert.Equal(t, uint64(1), binary.BigEndian.Uint64(resp[8:16]))
+
+	resp, err = dev.Poll()
+	assert.NoError(t, err)
+	assert.NotEqual(t, uint32(0), binary.BigEndian.Uint32(resp[4:8]))
+	assert.Equal(t, uint64(2), binary.BigEndian.Uint64(resp[8:16]))
+
+	buffer, err = dev.GetMetrics()
+	assert.NoError(t, err)
+	var m MetricEnvelop
+	assert.NoError(t, m.Deserialize(deserializer{}, buffer))
+	signCount, verifyCount, errorCount := m.Counter()
+	assert.Equal(t, 0, signCount)
+	assert.Equal(t, 1, verifyCount)
+	assert.Equal(t, 1, errorCount)
+}
+
+func TestSimulatorDevice_Close(t *testing.T) {
+	dev := NewSimulatorDevice()
+
+	done := make(chan error)
+	go func() {
+		_, err := dev.Poll()
+		done <- err
+	}()
+
+	assert.NoError(t, dev.Close())
+	assert.Error(t, <-done)
+	assert.Error(t, dev.Request(make([]byte, internal.SignRequestSize)))
+}
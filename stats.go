@@ -0,0 +1,160 @@
+/*
+Copyright Medium Corp. 2020 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package mediumpk
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets are upper bounds of latency histograms
+var latencyBuckets = []time.Duration{
+	50 * time.Microsecond,
+	100 * time.Microsecond,
+	250 * time.Microsecond,
+	500 * time.Microsecond,
+	1 * time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+}
+
+// histogram counts latencies into latencyBuckets, it is safe for concurrent use
+type histogram struct {
+	counts [15]uint64 // len(latencyBuckets)+1, the last one is +Inf
+	sum    int64
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := 0
+	for i < len(latencyBuckets) && d > latencyBuckets[i] {
+		i++
+	}
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddInt64(&h.sum, int64(d))
+}
+
+func (h *histogram) snapshot() LatencyHistogram {
+	snap := LatencyHistogram{
+		Buckets: latencyBuckets,
+		Counts:  make([]uint64, len(latencyBuckets)),
+	}
+	var cumulative uint64
+	for i := range h.counts {
+		cumulative += atomic.LoadUint64(&h.counts[i])
+		if i < len(latencyBuckets) {
+			snap.Counts[i] = cumulative
+		}
+	}
+	snap.Count = cumulative
+	snap.Sum = time.Duration(atomic.LoadInt64(&h.sum))
+	return snap
+}
+
+// opStats is host side statistics of an operation of a device
+type opStats struct {
+	requests   uint64
+	retries    uint64
+	slotFull   uint64
+	rerouted   uint64
+	checks     uint64
+	cpuChecks  uint64
+	mismatches uint64
+	queueWait  histogram
+	roundTrip  histogram
+}
+
+func (s *opStats) snapshot() OperationStats {
+	return OperationStats{
+		Requests:   atomic.LoadUint64(&s.requests),
+		Retries:    atomic.LoadUint64(&s.retries),
+		SlotFull:   atomic.LoadUint64(&s.slotFull),
+		Rerouted:   atomic.LoadUint64(&s.rerouted),
+		Checks:     atomic.LoadUint64(&s.checks),
+		CPUChecks:  atomic.LoadUint64(&s.cpuChecks),
+		Mismatches: atomic.LoadUint64(&s.mismatches),
+		QueueWait:  s.queueWait.snapshot(),
+		RoundTrip:  s.roundTrip.snapshot(),
+	}
+}
+
+// LatencyHistogram is a snapshot of latency histogram
+type LatencyHistogram struct {
+	// Buckets are upper bounds of buckets
+	Buckets []time.Duration
+	// Counts are cumulative counts of latencies less than or equal to each bucket
+	Counts []uint64
+	// Count is the number of all latencies
+	Count uint64
+	// Sum is the sum of all latencies
+	Sum time.Duration
+}
+
+// Mean returns mean latency
+func (h LatencyHistogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// OperationStats is host side statistics of sign or verify requests of a MBPU
+type OperationStats struct {
+	// Requests is the number of requests answered by MBPU
+	Requests uint64
+	// Retries is the number of retries to put request into a slot
+	Retries uint64
+	// SlotFull is the number of requests that waited because every slot was pending
+	SlotFull uint64
+	// Rerouted is the number of requests MBPU went down without answering, which are retried on another MBPU
+	Rerouted uint64
+	// Checks is the number of signatures generated by MBPU verified by consistency check
+	Checks uint64
+	// CPUChecks is the number of Checks verified on CPU. It includes checks of ConsistencyCheckDevice
+	// done on CPU because ConsistencyWorkers were busy or no other MBPU was up.
+	CPUChecks uint64
+	// Mismatches is the number of signatures generated by MBPU which did not verify for sign,
+	// and the number of valid signatures MBPU rejected while checking consistency for verify
+	Mismatches uint64
+	// QueueWait is the time from submission until request is sent to MBPU
+	QueueWait LatencyHistogram
+	// RoundTrip is the time from sending request to MBPU until its response arrives
+	RoundTrip LatencyHistogram
+}
+
+// DeviceStats is host side statistics of a MBPU
+type DeviceStats struct {
+	Index  int
+	Sign   OperationStats
+	Verify OperationStats
+	// Dropped is the number of response frames dropped as corrupted, stale or duplicated
+	Dropped uint64
+}
+
+// Stats returns host side statistics of each MBPU
+func (m *Manager) Stats() []DeviceStats {
+	stats := make([]DeviceStats, len(m.mpks))
+	for i, mpk := range m.mpks {
+		stats[i] = mpk.getStats()
+	}
+	return stats
+}
+
+// Stats returns host side statistics of each MBPU of the default Manager
+func Stats() []DeviceStats {
+	m := defaultManager()
+	if m == nil {
+		return nil
+	}
+	return m.Stats()
+}
@@ -0,0 +1,43 @@
+package mediumpk
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStats(t *testing.T) {
+	m := newTestManager(t, Options{Devices: []Device{NewSimulatorDevice()}})
+
+	for i := 0; i < 10; i++ {
+		workload := data[i]
+		env, err := NewVerifyRequestEnvelop(workload.qx, workload.qy, workload.r, workload.s, workload.h)
+		assert.NoError(t, err)
+		_, _, _, err = m.Request(env)
+		assert.NoError(t, err)
+	}
+
+	stats := m.Stats()
+	assert.Equal(t, 1, len(stats))
+	assert.Equal(t, 0, stats[0].Index)
+	assert.Equal(t, uint64(0), stats[0].Sign.Requests)
+	assert.Equal(t, uint64(10), stats[0].Verify.Requests)
+	assert.Equal(t, uint64(10), stats[0].Verify.QueueWait.Count)
+	assert.Equal(t, uint64(10), stats[0].Verify.RoundTrip.Count)
+	assert.True(t, stats[0].Verify.RoundTrip.Mean() > 0)
+}
+
+func TestHistogram(t *testing.T) {
+	var h histogram
+	h.observe(10 * time.Microsecond)
+	h.observe(3 * time.Millisecond)
+	h.observe(2 * time.Second)
+
+	snap := h.snapshot()
+	assert.Equal(t, uint64(3), snap.Count)
+	assert.Equal(t, uint64(1), snap.Counts[0])
+	assert.Equal(t, uint64(2), snap.Counts[6])
+	assert.Equal(t, uint64(2), snap.Counts[len(snap.Counts)-1])
+	assert.Equal(t, 2003010*time.Microsecond, snap.Sum)
+}
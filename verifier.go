@@ -0,0 +1,77 @@
+/*
+Copyright Medium Corp. 2020 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package mediumpk
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+)
+
+// Verify verifies ASN.1 DER encoded signature sig of hash with MBPU.
+// It returns false with nil error when the signature is not valid,
+// and error when sig or pub is malformed or the request is not served.
+func (m *Manager) Verify(pub *ecdsa.PublicKey, hash, sig []byte) (bool, error) {
+	var esig ecdsaSignature
+	rest, err := asn1.Unmarshal(sig, &esig)
+	if err != nil {
+		return false, ErrInvalidSignature
+	}
+	if len(rest) != 0 || esig.R == nil || esig.S == nil {
+		return false, ErrInvalidSignature
+	}
+
+	return m.VerifyRS(pub, hash, esig.R, esig.S)
+}
+
+// VerifyRS verifies signature r, s of hash with MBPU.
+// It returns false with nil error when the signature is not valid,
+// and error when pub is malformed or the request is not served.
+func (m *Manager) VerifyRS(pub *ecdsa.PublicKey, hash []byte, r, s *big.Int) (bool, error) {
+	return m.verifyRS(context.Background(), pub, hash, r, s)
+}
+
+func (m *Manager) verifyRS(ctx context.Context, pub *ecdsa.PublicKey, hash []byte, r, s *big.Int) (bool, error) {
+	if pub == nil || pub.X == nil || pub.Y == nil {
+		return false, ErrInvalidPublicKey
+	}
+	curve, err := CurveOf(pub.Curve)
+	if err != nil {
+		return false, err
+	}
+	if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+		return false, ErrInvalidPublicKey
+	}
+
+	// signature out of [1, N-1] never verifies
+	N := pub.Curve.Params().N
+	if r == nil || s == nil || r.Sign() <= 0 || s.Sign() <= 0 || r.Cmp(N) >= 0 || s.Cmp(N) >= 0 {
+		return false, nil
+	}
+
+	// ecdsa uses leftmost bits of hash as many as the order of curve
+	if size := curve.size(); len(hash) > size {
+		hash = hash[:size]
+	}
+
+	env, err := NewCurveVerifyRequestEnvelop(curve, pub.X.Bytes(), pub.Y.Bytes(), r.Bytes(), s.Bytes(), hash)
+	if err != nil {
+		return false, err
+	}
+
+	_, _, _, err = m.RequestContext(ctx, env)
+	if errors.Is(err, ErrVerifyFailed) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
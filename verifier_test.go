@@ -0,0 +1,67 @@
+package mediumpk
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerify(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	h := sha256.Sum256([]byte("Hello World"))
+
+	// find a signature with short r to check padding
+	var r, s *big.Int
+	for {
+		r, s, err = ecdsa.Sign(rand.Reader, priv, h[:])
+		assert.NoError(t, err)
+		if len(r.Bytes()) < 32 || len(s.Bytes()) < 32 {
+			break
+		}
+	}
+	der, err := asn1.Marshal(ecdsaSignature{r, s})
+	assert.NoError(t, err)
+
+	ok, err := Verify(&priv.PublicKey, h[:], der)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = VerifyRS(&priv.PublicKey, h[:], r, s)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = VerifyRS(&priv.PublicKey, h[:], r, new(big.Int).Add(s, big.NewInt(1)))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	ok, err = VerifyRS(&priv.PublicKey, h[:], r, elliptic.P256().Params().N)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestVerify_Malformed(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	h := sha256.Sum256([]byte("Hello World"))
+
+	_, err = Verify(&priv.PublicKey, h[:], []byte{0x30, 0x01})
+	assert.Equal(t, ErrInvalidSignature, err)
+
+	pub := priv.PublicKey
+	pub.Y = new(big.Int).Add(pub.Y, big.NewInt(1))
+	_, err = VerifyRS(&pub, h[:], big.NewInt(1), big.NewInt(1))
+	assert.Equal(t, ErrInvalidPublicKey, err)
+
+	params, err := ecdsa.GenerateKey(elliptic.P256().Params(), rand.Reader)
+	assert.NoError(t, err)
+	_, err = VerifyRS(&params.PublicKey, h[:], big.NewInt(1), big.NewInt(1))
+	assert.True(t, errors.Is(err, ErrUnsupportedCurve))
+}
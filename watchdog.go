@@ -0,0 +1,74 @@
+/*
+Copyright Medium Corp. 2020 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package mediumpk
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultRequestTimeout is the default of Options.RequestTimeout
+	defaultRequestTimeout = 10 * time.Second
+	// minWatchdogInterval is the shortest interval of checking pending requests
+	minWatchdogInterval = time.Millisecond
+)
+
+// runWatchdog runs watchdog-goroutine declaring MBPU stuck when a request is pending on it longer
+// than RequestTimeout. It reports to push-goroutine through chEmergency, and exits when stop is closed.
+// Poll blocked on MBPU stuck is cancelled if device is PollCanceler, so that health check can start.
+func (m *Mediumpk) runWatchdog(chEmergency chan error, stop <-chan struct{}) {
+	timeout := m.manager.requestTimeout
+	if timeout < 0 {
+		return
+	}
+	interval := timeout / 4
+	if interval < minWatchdogInterval {
+		interval = minWatchdogInterval
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			if age := m.oldestPending(); age > timeout {
+				m.logger.Error("MBPU is stuck", "oldestPending", age, "pending", atomic.LoadInt32(&m.count))
+				notifyEmergency(chEmergency, fmt.Errorf("%w: request pending for %v", ErrDeviceStuck, age))
+				if canceler, ok := m.dev.(PollCanceler); ok {
+					if err := canceler.CancelPoll(); err != nil {
+						m.logger.Warn("failed to cancel poll of stuck MBPU", "error", err)
+					}
+				}
+				return
+			}
+		}
+	}()
+}
+
+// oldestPending returns how long the oldest request pending on MBPU has been waiting for response
+func (m *Mediumpk) oldestPending() time.Duration {
+	m.storeLock.Lock()
+	defer m.storeLock.Unlock()
+
+	var oldest time.Time
+	for _, req := range m.chanStore {
+		if req != nil && (oldest.IsZero() || req.pushed.Before(oldest)) {
+			oldest = req.pushed
+		}
+	}
+	if oldest.IsZero() {
+		return 0
+	}
+	return time.Since(oldest)
+}
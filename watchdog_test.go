@@ -0,0 +1,80 @@
+package mediumpk
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchdog(t *testing.T) {
+	dev := newStuckDevice()
+	m := newTestManager(t, Options{Devices: []Device{dev, NewSimulatorDevice()}, RequestTimeout: 100 * time.Millisecond, HealthCheckInterval: -1})
+	atomic.StoreInt32(&dev.stuck, 1)
+
+	// request held by the stuck one is served by the other one
+	req := newRequestWrapper(context.Background(), newTestSignRequest(t))
+	m.mpks[0].chDirect <- req
+	result, _, _, err := req.wait()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result)
+	waitEmergency(t, m.mpks[0], 1)
+	assert.Equal(t, uint64(1), m.Stats()[0].Sign.Rerouted)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&m.mpks[1].emergency))
+}
+
+func TestWatchdog_Recovery(t *testing.T) {
+	dev := newStuckDevice()
+	m := newTestManager(t, Options{Devices: []Device{dev}, RequestTimeout: 50 * time.Millisecond, HealthCheckInterval: 10 * time.Millisecond})
+	atomic.StoreInt32(&dev.stuck, 1)
+
+	_, _, _, err := m.Request(newTestSignRequest(t))
+	assert.True(t, errors.Is(err, ErrDeviceDown))
+	waitEmergency(t, m.mpks[0], 1)
+
+	// poll blocked on the stuck one is canceled, so it is re-admitted once it responds again
+	atomic.StoreInt32(&dev.stuck, 0)
+	waitEmergency(t, m.mpks[0], 0)
+	result, _, _, err := m.Request(newTestSignRequest(t))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result)
+}
+
+func TestWatchdog_TinyTimeout(t *testing.T) {
+	// interval of checking is clamped
+	m := newTestManager(t, Options{Devices: []Device{NewSimulatorDevice()}, RequestTimeout: time.Nanosecond, HealthCheckInterval: -1})
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&m.mpks[0].emergency))
+}
+
+func TestWatchdog_Disabled(t *testing.T) {
+	dev := newStuckDevice()
+	m := newTestManager(t, Options{Devices: []Device{dev}, RequestTimeout: -1})
+	atomic.StoreInt32(&dev.stuck, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, _, _, err := m.RequestContext(ctx, newTestSignRequest(t))
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&m.mpks[0].emergency))
+	close(dev.release)
+}
+
+func TestOldestPending(t *testing.T) {
+	m := newTestManager(t, Options{Devices: []Device{NewSimulatorDevice()}})
+	mpk := m.mpks[0]
+	assert.Equal(t, time.Duration(0), mpk.oldestPending())
+
+	mpk.storeLock.Lock()
+	mpk.chanStore[3] = &requestWrapper{pushed: time.Now().Add(-time.Minute)}
+	mpk.chanStore[5] = &requestWrapper{pushed: time.Now()}
+	mpk.storeLock.Unlock()
+	assert.True(t, mpk.oldestPending() >= time.Minute)
+
+	mpk.storeLock.Lock()
+	mpk.chanStore[3], mpk.chanStore[5] = nil, nil
+	mpk.storeLock.Unlock()
+}